@@ -26,12 +26,24 @@ import (
 )
 
 var cfgFile string
+var profile string
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "go",
 	Short: "CLI tool for nventory",
-	Long:  `The opsdb-cli utility is used to query results from nventory, and is extendable to use other systems.`,
+	Long: `The opsdb-cli utility is used to query results from nventory, and is extendable to use other systems.
+
+Exit codes:
+  0    success
+  1    any other error
+  2    invalid flags or arguments
+  3    authentication failed
+  4    object not found
+  5    server error, or the server can't be reached
+  6    the server's response can't be parsed
+  7    some of the requested changes failed
+  130  interrupted`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
@@ -53,7 +65,26 @@ func init() {
 	// Cobra supports Persistent Flags, which, if defined here,
 	// will be global for your application.
 
-	//RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.go.yaml)")
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Config file in nventory.conf format (key = value),\n\t read after /etc/nventory.conf and ~/.nventory.conf")
+	RootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Profile of the config file to use, defined by profiles.<name>.<setting> = value lines.\n\t Defaults to $NV_PROFILE, then to the profile set by 'profile use'.")
+}
+
+// FlagValue returns the value of the flag --name given in args. The config
+// file and profile set the defaults of the other flags, so --config and
+// --profile are needed before the flags are parsed.
+func FlagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			return strings.TrimPrefix(arg, "--"+name+"=")
+		}
+	}
+	return ""
 }
 
 // initConfig reads in config file and ENV variables if set.
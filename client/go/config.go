@@ -27,6 +27,56 @@ import (
 	"net/url"
 )
 
+// Exit codes of the CLI, one for each kind of error returned by nvclient:
+//	0	success
+//	1	any other error
+//	2	invalid flags or arguments (nvclient.ErrUsage)
+//	3	authentication failed (nvclient.ErrAuth)
+//	4	object not found (nvclient.ErrNotFound)
+//	5	server error, or the server can't be reached (nvclient.ErrServer)
+//	6	the server's response can't be parsed (nvclient.ErrParse)
+//	7	some of the requested changes failed (nvclient.ErrPartialUpdate)
+//	130	interrupted (nvclient.ErrCanceled)
+const (
+	ExitOK = iota
+	ExitError
+	ExitUsage
+	ExitAuth
+	ExitNotFound
+	ExitServer
+	ExitParse
+	ExitPartialUpdate
+
+	ExitCanceled = 130
+)
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	switch nvclient.ErrorKind(err) {
+	case nvclient.ErrUsage:
+		return ExitUsage
+	case nvclient.ErrAuth:
+		return ExitAuth
+	case nvclient.ErrNotFound:
+		return ExitNotFound
+	case nvclient.ErrServer:
+		return ExitServer
+	case nvclient.ErrParse:
+		return ExitParse
+	case nvclient.ErrPartialUpdate:
+		return ExitPartialUpdate
+	case nvclient.ErrCanceled:
+		return ExitCanceled
+	}
+	return ExitError
+}
+
+// exitWithError prints err and exits with its exit code.
+func exitWithError(err error) {
+	logger.Error.Println("Error:", err)
+	os.Exit(exitCode(err))
+}
+
 /******************************************************************************
 SetupCli:
 	Initializes cobra command (app) with
@@ -36,74 +86,198 @@ func SetupCli(app *cobra.Command, driver nvclient.Driver) {
 	app.Run = func(cmd *cobra.Command, args []string) { println("Running dummy command. Please overwrite.") }
 
 	app.PreRun = func(cmd *cobra.Command, args []string) {
-		if searchCommand.IsDebug() {
-			logger.InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr, os.Stdout)
-			logger.Debug.Println("Debug logging turned on!")
-		} else {
-			logger.InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr, ioutil.Discard)
-		}
-
-
-		host := searchCommand.GetServer()
-		u, err := url.Parse(host)
-
-		if err != nil {
-			logger.Error.Printf("Error parsing host: %v\n", err)
-			os.Exit(1)
-		}
-		if u.Host == "" {
-			u.Host = host
-		}
-		if u.Scheme == "" {
-			u.Scheme = "http"
-		}
-		u.Path = ""
-		host = u.String()
-
-		driver.SetServer(host)
-		logger.Debug.Printf("Using %v as server (%v)\n", driver.GetServer(), searchCommand.GetServer())
+		configureDriver(driver)
 
 		app.Run = func(cmd *cobra.Command, args []string) {
 			if searchCommand.IsShowVersion() {
 				fmt.Printf("%v version %v\n", filepath.Base(os.Args[0]), searchCommand.GetVersion())
-				os.Exit(0)
+				os.Exit(ExitOK)
+			}
+
+			if searchCommand.IsRegister() {
+				printMutationResult(nvclient.RegisterByCommand(driver, searchCommand))
+				return
+			}
+
+			if len(setCommand.GetCreateNodeGroup()) > 0 {
+				printMutationResult(setCommand.CreateNodeGroupByCommand(driver))
+				return
+			}
+
+			if len(setCommand.GetAddNodeGroupToNodeGroup()) > 0 {
+				printMutationResult(setCommand.AddNodeGroupToNodeGroupByCommand(driver))
+				return
+			}
+			if len(setCommand.GetRemoveNodeGroupFromNodeGroup()) > 0 {
+				printMutationResult(setCommand.RemoveNodeGroupFromNodeGroupByCommand(driver))
+				return
+			}
+			if len(setCommand.GetCreateTag()) > 0 {
+				printMutationResult(setCommand.CreateTagByCommand(driver))
+				return
+			}
+			if len(setCommand.GetAddTagToNodeGroup()) > 0 {
+				printMutationResult(setCommand.AddTagToNodeGroupByCommand(driver))
+				return
+			}
+			if len(setCommand.GetRemoveTagFromNodeGroup()) > 0 {
+				printMutationResult(setCommand.RemoveTagFromNodeGroupByCommand(driver))
+				return
+			}
+			if searchCommand.GetSearchFlags().Tag != "" && searchCommand.GetObjectType() != "node_groups" {
+				fmt.Printf("--tag can only be used with objecttype node_groups. object type = %v\n", searchCommand.GetObjectType())
+				os.Exit(ExitUsage)
+			}
+			if err := searchCommand.CheckShowTags(); err != nil {
+				exitWithError(err)
+			}
+			if searchCommand.IsGetFieldNames() {
+				fields, err := nvclient.GetFieldNamesByCommand(driver, searchCommand)
+				if err != nil {
+					exitWithError(err)
+				}
+				if searchCommand.GetOutput() == nvclient.OutputText && searchCommand.GetFormat() == "" {
+					for _, field := range fields {
+						fmt.Println(field)
+					}
+				} else {
+					printSearchResults(nvclient.FieldNamesToResult(fields), []string{})
+				}
+				return
+			}
+			if len(searchCommand.GetAllValues()) > 0 {
+				values, err := nvclient.GetAllValuesByCommand(driver, searchCommand)
+				if err != nil {
+					exitWithError(err)
+				}
+				if searchCommand.GetOutput() == nvclient.OutputText && searchCommand.GetFormat() == "" {
+					fmt.Print(nvclient.PrintAllValues(values, searchCommand.IsCountValues()))
+				} else {
+					printSearchResults(nvclient.AllValuesToResult(values, searchCommand.IsCountValues()), []string{})
+				}
+				return
+			}
+			if searchCommand.IsNodeGroup() {
+				printMutationResult(nvclient.NodeGroupByCommand(driver, searchCommand))
+				return
+			}
+			if searchCommand.IsNodeGroupNodes() {
+				printMutationResult(nvclient.NodeGroupExpandedByCommand(driver, searchCommand))
+				return
 			}
 
 			var err = nvclient.AssignIfStringSliceFlagNotExists(searchCommand.GetSearchFlags(), 0)
 			if err != nil {
 				fmt.Print(app.UsageString())
-				os.Exit(1)
+				os.Exit(ExitUsage)
 			}
 
-			if setCommand.GetSetValueFlags() != nil && len(setCommand.GetSetValueFlags().GetValues()) > 0 {
+			if setCommand.IsDelete() {
+				logger.Debug.Printf("Delete option is specified. Changing action to delete instead of search.\n")
+				printMutationResult(setCommand.DeleteByCommand(driver))
+			} else if setCommand.GetAddComment() != "" {
+				printMutationResult(setCommand.AddCommentByCommand(driver))
+			} else if setCommand.GetAddGraffiti() != "" {
+				printMutationResult(setCommand.AddGraffitiByCommand(driver))
+			} else if setCommand.GetDeleteGraffiti() != "" {
+				printMutationResult(setCommand.DeleteGraffitiByCommand(driver))
+			} else if len(setCommand.GetAddToNodeGroup()) > 0 {
+				printMutationResult(setCommand.AddToNodeGroupByCommand(driver))
+			} else if len(setCommand.GetRemoveFromNodeGroup()) > 0 {
+				printMutationResult(setCommand.RemoveFromNodeGroupByCommand(driver))
+			} else if setCommand.GetSetValueFlags() != nil && len(setCommand.GetSetValueFlags().GetValues()) > 0 {
 				logger.Debug.Printf("Set option is specified. Changing action to set instead of search.\n")
-				res, err := setCommand.SetByCommand(driver)
-				if err != nil {
-					fmt.Println(err)
-					os.Exit(1)
-				} else {
-					fmt.Print(res)
-				}
+				printMutationResult(setCommand.SetByCommand(driver))
 			} else {
 				// Check if --allfields is called.
 				if searchCommand.IsAllFields() {
 					val, err := nvclient.GetAllFieldsByCommand(driver, searchCommand)
-					if err == nil {
+					if err == nil && searchCommand.GetOutput() == nvclient.OutputText && searchCommand.GetFormat() == "" {
 						fmt.Print(nvclient.PrintResults(val))
+					} else if err == nil {
+						printSearchResults(val, []string{})
 					} else {
-						logger.Error.Println("Error:", err)
-						os.Exit(1)
+						exitWithError(err)
 					}
 					return
 				}
 
 				val, err := nvclient.SearchByCommand(driver, searchCommand)
-				if err == nil {
-					fmt.Print(nvclient.PrintResultsFilterByFields(val, searchCommand.GetFieldsArray()))
-				} else {
-					logger.Error.Println("Error:", err)
+				if err != nil {
+					exitWithError(err)
 				}
+				printSearchResults(val, searchCommand.GetFieldsArray())
 			}
 		}
 	}
 }
+
+// configureDriver sets up logging and the driver from the flags shared by all
+// commands.
+func configureDriver(driver nvclient.Driver) {
+	if searchCommand.IsDebug() {
+		logger.InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr, os.Stdout)
+		logger.Debug.Println("Debug logging turned on!")
+	} else {
+		logger.InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr, ioutil.Discard)
+	}
+
+	if profileErr != nil {
+		exitWithError(profileErr)
+	}
+
+	host := searchCommand.GetServer()
+	u, err := url.Parse(host)
+
+	if err != nil {
+		logger.Error.Printf("Error parsing host: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if u.Host == "" {
+		u.Host = host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	u.Path = ""
+	host = u.String()
+
+	driver.SetServer(host)
+	logger.Debug.Printf("Using %v as server (%v)\n", driver.GetServer(), searchCommand.GetServer())
+	driver.SetDryRun(searchCommand.IsDryRun())
+	driver.SetTimeout(searchCommand.GetTimeout())
+	driver.SetUsername(searchCommand.GetUsername())
+	driver.SetCredentialOptions(credentialOptionsFromConfig())
+	if err := driver.SetProxy(searchCommand.GetProxy()); err != nil {
+		exitWithError(err)
+	}
+	if err := driver.SetTLSOptions(tlsOptionsFromConfig(searchCommand.IsInsecure())); err != nil {
+		exitWithError(err)
+	}
+}
+
+// printSearchResults prints val using --format if given, otherwise --output.
+func printSearchResults(val nvclient.Result, fields []string) {
+	var out string
+	var err error
+	if searchCommand.GetFormat() != "" {
+		out, err = nvclient.FormatResultsWithTemplate(val, searchCommand.GetFormat())
+	} else {
+		out, err = nvclient.FormatResults(val, fields, searchCommand.GetOutput())
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Print(out)
+}
+
+// printMutationResult prints the summary of a set/delete style command (or a
+// node group listing) and exits with the exit code of err if any part of it
+// failed.
+func printMutationResult(res string, err error) {
+	fmt.Print(res)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitCode(err))
+	}
+}
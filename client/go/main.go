@@ -16,7 +16,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"os"
+	"os/signal"
 
 	"github.com/atclate/nventory/client/go/cmd"
 	"github.com/atclate/nventory/client/go/nvclient"
@@ -32,6 +34,8 @@ var (
 
 	autoreg_password = "REPLACE_ME_WITH_AUTOREG_PASSWORD"
 	defaultOpsdbServer = "http://nventory"
+	currentProfile     string
+	profileErr         error // unknown profile, reported unless running the profile command
 
 	driver nvclient.Driver
 )
@@ -47,6 +51,8 @@ func init() {
 	searchCommand.InitializeCommand(cmd.RootCmd)
 	setCommand = nvclient.NewSetCommand(cmd.RootCmd, searchCommand, driver);
 	SetupCli(cmd.RootCmd, driver)
+	SetupProfileCommand(cmd.RootCmd)
+	SetupSessionCommands(cmd.RootCmd, driver)
 
 }
 
@@ -56,7 +62,6 @@ func initConfigFile() {
 
 	viper.SetConfigName("nventory") // name of config file (without extension)
 	viper.SetConfigType("yml")
-	viper.SupportedExts = append(viper.SupportedExts, "conf")
 	viper.AddConfigPath("/etc/")   // path to look for the config file in
 	viper.AddConfigPath("$HOME/")  // call multiple times to add many search paths
 	viper.AddConfigPath(".")               // optionally look for config in the working directory
@@ -70,11 +75,111 @@ func initConfigFile() {
 		println("Config file changed:", e.Name)
 	})
 
+	// key = value settings of the nventory.conf files shared with the ruby
+	// client, then of --config, override the yml ones.
+	files := nvclient.ConfigFiles()
+	if cfgFile := cmd.FlagValue(os.Args[1:], "config"); cfgFile != "" {
+		if _, err := os.Stat(cfgFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unable to read config file %v: %v\n", cfgFile, err)
+			os.Exit(ExitUsage)
+		}
+		files = append(files, cfgFile)
+	}
+	settings, sources, err := nvclient.ReadConfigFiles(files...)
+	if err != nil {
+		jww.ERROR.Println(err)
+	}
+	for key, value := range settings {
+		jww.DEBUG.Println(fmt.Sprintf("Using %v from %v", key, sources[key]))
+		viper.Set(key, value)
+	}
+
+	// settings of the profile override the others
+	currentProfile = cmd.FlagValue(os.Args[1:], "profile")
+	if currentProfile == "" {
+		currentProfile = os.Getenv("NV_PROFILE")
+	}
+	if currentProfile == "" {
+		currentProfile = viper.GetString("profile")
+	}
+	if currentProfile != "" {
+		var profileSettings map[string]string
+		profileSettings, profileErr = nvclient.ProfileSettings(configSettings(), currentProfile)
+		for key, value := range profileSettings {
+			jww.DEBUG.Println(fmt.Sprintf("Using %v of profile %v", key, currentProfile))
+			viper.Set(key, value)
+		}
+		driver.SetProfile(currentProfile)
+	}
+
 	// use server from config file.
 	driver.SetServer(viper.GetString("server"))
+	driver.SetSSOServer(viper.GetString("sso_server"))
+	driver.SetSSOLogoutURL(viper.GetString("sso_logout_url"))
+	if err := driver.SetAuthMethod(viper.GetString("auth")); err != nil {
+		jww.ERROR.Print(err)
+	}
+	driver.SetCookieFile(viper.GetString("cookiefile"))
+	if err := driver.SetCookieFormat(viper.GetString("cookie_format")); err != nil {
+		jww.ERROR.Print(err)
+	}
 	searchCommand.SetDefaultServer(viper.GetString("server"))
+	searchCommand.SetDefaultProxy(viper.GetString("proxy_server"))
+	searchCommand.SetDefaultUsername(viper.GetString("username"))
+
+	if timeout := viper.GetString("timeout"); timeout != "" {
+		t, err := nvclient.ParseTimeout(timeout)
+		if err != nil {
+			jww.ERROR.Println(fmt.Sprintf("invalid timeout %v in config file: %v", timeout, err))
+		}
+		searchCommand.SetDefaultTimeout(t)
+	}
+}
+
+// configSettings returns all the settings of the config files, with the keys
+// of nested yml settings joined by dots, like profiles.<name>.server.
+func configSettings() map[string]string {
+	settings := make(map[string]string, 0)
+	for _, key := range viper.AllKeys() {
+		settings[key] = viper.GetString(key)
+	}
+	return settings
+}
+
+// credentialOptionsFromConfig returns where the password to log in with
+// comes from: --password-file, --password-stdin and netrc_file in the config
+// file.
+func credentialOptionsFromConfig() nvclient.CredentialOptions {
+	return nvclient.CredentialOptions{
+		PasswordFile:  searchCommand.GetPasswordFile(),
+		PasswordStdin: searchCommand.IsPasswordStdin(),
+		NetrcFile:     viper.GetString("netrc_file"),
+	}
+}
+
+// tlsOptionsFromConfig returns the CAs and client certificate to use from the
+// config file, ca_file and ca_path being the same as for the ruby client.
+func tlsOptionsFromConfig(insecure bool) nvclient.TLSOptions {
+	return nvclient.TLSOptions{
+		CAFile:     viper.GetString("ca_file"),
+		CAPath:     viper.GetString("ca_path"),
+		ClientCert: viper.GetString("client_cert"),
+		ClientKey:  viper.GetString("client_key"),
+		Insecure:   insecure,
+	}
 }
 
 func main() {
-	cmd.RootCmd.Execute()
+	// The first SIGINT cancels the requests in flight and the prompts so the
+	// command stops cleanly, the next one kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	driver.SetBaseContext(ctx)
+
+	if err := cmd.RootCmd.Execute(); err != nil {
+		os.Exit(ExitUsage)
+	}
 }
@@ -0,0 +1,368 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// The ways of logging in to the server, chosen with the auth setting.
+const (
+	AuthSSO    = "sso"    // the SSO server's login form, the default
+	AuthLocal  = "local"  // the nventory server's own /login/login form
+	AuthBasic  = "basic"  // HTTP Basic authentication
+	AuthBearer = "bearer" // a bearer token, looked up like a password
+)
+
+var AuthMethods = []string{AuthSSO, AuthLocal, AuthBasic, AuthBearer}
+
+// maxLoginRedirects is how many redirects of the SSO server are followed
+// while logging in, and of the server while checking for a session.
+const maxLoginRedirects = 7
+
+/*******
+ * Authenticator logs http clients in to the nventory server. The server is
+ * asked for accounts.xml first: when the answer asks to log in, Login is
+ * called with it. The password comes from the HttpClient's credentials, see
+ * CredentialOptions, and the session cookies are saved in the user's cookie
+ * jar.
+ *******/
+type Authenticator interface {
+	// LoginRequired tells if resp, the answer of the server or a redirect
+	// it made, asks to log in. Other redirects are followed.
+	LoginRequired(resp *http.Response) bool
+
+	// Login logs client in as username. resp is the answer that asked to.
+	Login(ctx context.Context, client *http.Client, username string, resp *http.Response) error
+}
+
+// SetAuthMethod sets how users log in to the server, one of AuthMethods. The
+// default is AuthSSO.
+func (c *HttpClient) SetAuthMethod(method string) error {
+	switch method {
+	case "", AuthSSO, AuthLocal, AuthBasic, AuthBearer:
+		c.authMethod = method
+		return nil
+	}
+	return newError(ErrUsage, "Unknown auth %v, must be one of %v.\n", method, strings.Join(AuthMethods, ", "))
+}
+
+// SetAuthenticator makes every user log in with a, instead of the
+// authenticator of the auth method.
+func (c *HttpClient) SetAuthenticator(a Authenticator) {
+	c.authenticator = a
+}
+
+// authenticatorFor returns the authenticator username logs in with. Being a
+// local account, autoreg logs in with the local form on SSO servers.
+func (c *HttpClient) authenticatorFor(username string) Authenticator {
+	if c.authenticator != nil {
+		return c.authenticator
+	}
+	switch c.authMethod {
+	case AuthLocal:
+		return &localAuthenticator{c: c}
+	case AuthBasic:
+		return &headerAuthenticator{c: c, scheme: "Basic"}
+	case AuthBearer:
+		return &headerAuthenticator{c: c, scheme: "Bearer"}
+	}
+	if username == autoreg {
+		return &localAuthenticator{c: c}
+	}
+	return &ssoAuthenticator{c: c}
+}
+
+/*******
+ * ssoAuthenticator posts the login form of the SSO server the nventory
+ * server redirects to, sso_server or else any https://sso* host, then gets
+ * the session token of the nventory server from it.
+ *******/
+type ssoAuthenticator struct {
+	c *HttpClient
+}
+
+// Without sso_server, any https://sso* host is taken for the SSO server.
+var (
+	ssoLocation      = regexp.MustCompile(`^https:\/\/sso.*`)
+	ssoTokenLocation = regexp.MustCompile(`^(http|https):\/\/(sso.*)\/session\/tokens`)
+)
+
+// SetSSOServer sets the SSO server the nventory server redirects to for
+// logging in, like sso_server in nventory.conf, e.g. https://sso.example.com/.
+func (c *HttpClient) SetSSOServer(ssoServer string) {
+	c.ssoHost = ssoServer
+	if u, err := url.Parse(ssoServer); err == nil && u.Host != "" {
+		c.ssoHost = u.Host
+	}
+}
+
+// SetSSOLogoutURL sets where the SSO server ends the session of its token
+// cookie, like sso_logout_url in nventory.conf, e.g.
+// https://sso.example.com/logout. Without it, logging out of an SSO session
+// only removes its cookies.
+func (c *HttpClient) SetSSOLogoutURL(logoutURL string) {
+	c.ssoLogoutURL = logoutURL
+}
+
+// isSSODomain tells if domain, the domain of a cookie, is the SSO server's.
+func (c *HttpClient) isSSODomain(domain string) bool {
+	if c.ssoHost == "" {
+		return ssoLocation.MatchString("https://" + domain + "/")
+	}
+	return domain == stripPort(c.ssoHost)
+}
+
+// isSSOLocation tells if location is on the SSO server and matches pattern
+// when no SSO server is set, or has path in it when one is.
+func (c *HttpClient) isSSOLocation(location string, pattern *regexp.Regexp, path string) bool {
+	if c.ssoHost == "" {
+		return pattern.MatchString(location)
+	}
+	u, err := url.Parse(location)
+	return err == nil && u.Host == c.ssoHost && strings.Contains(location, path)
+}
+
+// cantConnect is the error of SSO servers missing Crypt::SSLeay.
+var cantConnect = regexp.MustCompile(`Can't connect .* Invalid argument`)
+
+func (a *ssoAuthenticator) LoginRequired(resp *http.Response) bool {
+	return isRedirectResponse(resp) && a.c.isSSOLocation(getHeaderLocation(resp), ssoLocation, "")
+}
+
+func (a *ssoAuthenticator) Login(ctx context.Context, client *http.Client, username string, resp *http.Response) error {
+	location := getHeaderLocation(resp)
+	cookieHost := hostOf(location)
+	if !a.isTokenRedirect(resp) {
+		logger.Debug.Printf("POST to %v/accounts.xml was redirected, authenticating to SSO\n", a.c.GetServer())
+		password, err := a.c.password(username, hostOf(location), hostOf(a.c.GetServer()))
+		if err != nil {
+			return err
+		}
+		v := url.Values{}
+		v.Set("login", username)
+		v.Set("password", password)
+
+		// the redirects of the SSO server are looked at here, the form is
+		// posted again to the host they lead to
+		redirFunc := client.CheckRedirect
+		client.CheckRedirect = NoRedirectFunc
+		defer func() { client.CheckRedirect = redirFunc }()
+
+		cookies := make([]*http.Cookie, 0)
+		for i := 0; ; i++ {
+			if i == maxLoginRedirects {
+				return newError(ErrAuth, "SSO redirect loop")
+			}
+			if err := ctx.Err(); err != nil {
+				return wrapError(ErrCanceled, err, "Authentication to %v canceled: %v\n", location, err)
+			}
+			urlStr := fmt.Sprintf("https://%v/login?noredirects=1", hostOf(location))
+			fmt.Printf("Authenticating to %v...\n", urlStr)
+			resp, err = postForm(ctx, client, urlStr, v)
+			if err != nil && handleResponseError(err) != nil {
+				return requestError(ctx, err, urlStr)
+			}
+			cookies = append(cookies, resp.Cookies()...)
+			logger.Debug.Printf("Response: %v", describeResponse(resp))
+			location = getHeaderLocation(resp)
+
+			if resp.StatusCode == 200 || a.isTokenRedirect(resp) {
+				logger.Debug.Printf("Authentication Successful to %v\n", cookieHost)
+				a.c.saveCookies(username, cookies, cookieHost)
+				break
+			} else if !isRedirect(resp.StatusCode) {
+				body, err := readResponseBody(resp.Body)
+				if err != nil {
+					return newError(ErrServer, "Unable to read response body.")
+				}
+				if cantConnect.MatchString(body) {
+					logger.Debug.Println("Looks like you're missing Crypt::SSLeay")
+					return newError(ErrServer, "Cannot connect. Looks like you're missing Crypt::SSLeay")
+				}
+				return newError(ErrAuth, "Authentication failed: %v\n", resp.Status)
+			}
+			logger.Debug.Printf("Redirected to %v\n", location)
+		}
+	}
+
+	if a.isTokenRedirect(resp) {
+		return a.getToken(ctx, client, getHeaderLocation(resp))
+	}
+	return nil
+}
+
+func (a *ssoAuthenticator) isTokenRedirect(resp *http.Response) bool {
+	return isRedirectResponse(resp) && a.c.isSSOLocation(getHeaderLocation(resp), ssoTokenLocation, "/session/tokens")
+}
+
+// getToken gets the session token of the nventory server from the SSO
+// server, which redirects back to the nventory server with it. The redirects
+// of the nventory server after that aren't followed.
+func (a *ssoAuthenticator) getToken(ctx context.Context, client *http.Client, location string) error {
+	getClient := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 2 {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+		Jar:       client.Jar,
+		Transport: client.Transport,
+		Timeout:   client.Timeout,
+	}
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return wrapError(ErrAuth, err, "fatal error: %v", err)
+	}
+	resp, err := getClient.Do(req.WithContext(ctx))
+	if ctx.Err() != nil {
+		return requestError(ctx, err, req.URL.String())
+	}
+	if err != nil {
+		return wrapError(ErrAuth, err, "fatal error: %v", err)
+	}
+	readResponseBody(resp.Body)
+	// without a valid session the SSO server answers itself, with its login
+	// page, instead of redirecting back
+	if a.c.isSSOLocation(resp.Request.URL.String(), ssoLocation, "") {
+		return newError(ErrAuth, "Unable to get SSO session token.  Might be authentication failure or SSO problem\n")
+	}
+	return nil
+}
+
+/*******
+ * localAuthenticator posts the login form of the nventory server itself,
+ * /login/login over https, for accounts like autoreg that aren't on the SSO
+ * server.
+ *******/
+type localAuthenticator struct {
+	c *HttpClient
+}
+
+// localLoginPath matches the paths of the login pages the nventory server
+// redirects to, its own or the SSO server's.
+var localLoginPath = regexp.MustCompile(`^/login(/login)?/?$`)
+
+func (a *localAuthenticator) LoginRequired(resp *http.Response) bool {
+	if !isRedirectResponse(resp) {
+		return false
+	}
+	location := getHeaderLocation(resp)
+	u, err := url.Parse(location)
+	return a.c.isSSOLocation(location, ssoLocation, "") || (err == nil && localLoginPath.MatchString(u.Path))
+}
+
+func (a *localAuthenticator) Login(ctx context.Context, client *http.Client, username string, resp *http.Response) error {
+	logger.Debug.Printf("POST to %v/accounts.xml ( ** for user '%v' ** ) was redirected, authenticating to local login path: '/login/login'\n", a.c.GetServer(), username)
+	host := hostOf(a.c.GetServer())
+	urlStr := fmt.Sprintf("https://%v/login/login", host)
+	password, err := a.c.password(username, host)
+	if err != nil {
+		return err
+	}
+
+	v := url.Values{}
+	v.Set("login", username)
+	v.Set("password", password)
+	// the server redirects back to the login page when the password is
+	// wrong, so the redirect isn't followed to tell
+	redirFunc := client.CheckRedirect
+	client.CheckRedirect = NoRedirectFunc
+	defer func() { client.CheckRedirect = redirFunc }()
+	logger.Debug.Printf("Authenticating to %v\n", urlStr)
+	resp, err = postForm(ctx, client, urlStr, v)
+	if err != nil && handleResponseError(err) != nil {
+		if ctx.Err() != nil {
+			return requestError(ctx, err, urlStr)
+		}
+		return wrapError(ErrAuth, err, "Unable to log in to %v: %v\n", urlStr, err)
+	}
+	readResponseBody(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return newError(ErrAuth, "Authentication failed: %v\n", resp.Status)
+	}
+	if a.LoginRequired(resp) {
+		return newError(ErrAuth, "Authentication failed: invalid login/password combination for %v\n", username)
+	}
+	a.c.saveCookies(username, resp.Cookies(), host)
+	return nil
+}
+
+/*******
+ * headerAuthenticator sends the password in the Authorization header of every
+ * request to the nventory server: HTTP Basic, or a bearer token. Cookies the
+ * server sets are saved, so it can keep a session too.
+ *******/
+type headerAuthenticator struct {
+	c      *HttpClient
+	scheme string // Basic or Bearer
+}
+
+func (a *headerAuthenticator) LoginRequired(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusUnauthorized
+}
+
+func (a *headerAuthenticator) Login(ctx context.Context, client *http.Client, username string, resp *http.Response) error {
+	host := hostOf(a.c.GetServer())
+	password, err := a.c.password(username, host)
+	if err != nil {
+		return err
+	}
+	authorization := a.scheme + " " + password
+	if a.scheme == "Basic" {
+		authorization = a.scheme + " " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &authTransport{base: base, host: host, authorization: authorization}
+
+	loggedIn, resp, err := a.c.isLoggedIn(ctx, a.c.GetServer(), client, a)
+	if err != nil {
+		return requestError(ctx, err, a.c.GetServer())
+	}
+	if !loggedIn {
+		return newError(ErrAuth, "Authentication failed: %v\n", resp.Status)
+	}
+	a.c.saveCookies(username, resp.Cookies(), host)
+	return nil
+}
+
+// authTransport adds the Authorization header to the requests to host, and
+// to no other, so redirects elsewhere don't get the password.
+type authTransport struct {
+	base          http.RoundTripper
+	host          string
+	authorization string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", t.authorization)
+	return t.base.RoundTrip(req)
+}
@@ -16,14 +16,14 @@ package nvclient
 
 import (
 	"bufio"
-	"errors"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/lestrrat/go-libxml2"
 	"github.com/lestrrat/go-libxml2/clib"
@@ -38,7 +38,29 @@ import (
 type Client interface {
 	GetObjects(objecttypes string, conditions Conditions, includes []string) (Result, error)
 	SetObjects(objecttypes string, conditions Conditions, includes []string, set map[string]string, login string, yes bool) (string, error)
+	DeleteObjects(objecttypes string, conditions Conditions, includes []string, login string, yes bool) (string, error)
+	AddNodesToNodeGroups(conditions Conditions, nodeGroups []string, login string, yes bool) (string, error)
+	RemoveNodesFromNodeGroups(conditions Conditions, nodeGroups []string, login string, yes bool) (string, error)
+	CreateNodeGroups(nodeGroups []string, login string, yes bool) (string, error)
+	AddNodeGroupsToNodeGroups(childGroups []string, parentGroups []string, login string, yes bool) (string, error)
+	RemoveNodeGroupsFromNodeGroups(childGroups []string, parentGroups []string, login string, yes bool) (string, error)
+	CreateTags(tags []string, login string, yes bool) (string, error)
+	AddTagToNodeGroups(tag string, nodeGroups []string, login string, yes bool) (string, error)
+	RemoveTagFromNodeGroups(tag string, nodeGroups []string, login string, yes bool) (string, error)
+	AddGraffiti(objecttypes string, conditions Conditions, graffiti string, login string, yes bool) (string, error)
+	DeleteGraffiti(objecttypes string, conditions Conditions, name string, login string, yes bool) (string, error)
+	AddComment(objecttypes string, conditions Conditions, comment string, login string, yes bool) (string, error)
+	GetNodeGroupMembers(nodeGroup string) (*NodeGroupMembers, error)
+	GetExpandedNodeGroups(nodeGroups []string) ([]string, error)
 	GetAllSubsystemNames(objectType string) ([]string, error)
+	GetFieldNames(objectType string) ([]FieldName, error)
+	Register(data map[string]string) (string, error)
+
+	// Same as GetObjects, SetObjects and GetAllSubsystemNames, with the
+	// requests canceled when ctx is done.
+	GetObjectsContext(ctx context.Context, objecttypes string, conditions Conditions, includes []string) (Result, error)
+	SetObjectsContext(ctx context.Context, objecttypes string, conditions Conditions, includes []string, set map[string]string, login string, yes bool) (string, error)
+	GetAllSubsystemNamesContext(ctx context.Context, objectType string) ([]string, error)
 }
 
 func NewNventoryClient(login string, input *bufio.Reader) *NventoryClient {
@@ -46,6 +68,7 @@ func NewNventoryClient(login string, input *bufio.Reader) *NventoryClient {
 		username:           login,
 		Input:              input,
 		HttpClient:         NewHttpClient(),
+		subsystemNames:     make(map[string][]string, 0),
 	}
 	return client
 }
@@ -56,8 +79,12 @@ type NventoryClient struct {
 	HttpClient     *HttpClient
 
 	Input          *bufio.Reader
+	dryRun         bool
 
-	subsystemNames []string
+	// ctx cancels the requests of the client, see withContext.
+	ctx            context.Context
+
+	subsystemNames map[string][]string
 }
 
 type Conditions map[string][]string
@@ -85,7 +112,86 @@ func (c *NventoryClient) SetServer(server string) {
 	c.HttpClient.SetServer(server)
 }
 
-func (f *NventoryClient) GetHttpClientFor(username string) *http.Client {
+func (c *NventoryClient) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// SetBaseContext sets the context of the requests made by the methods that
+// don't take one, so that canceling ctx (e.g. on SIGINT) cancels them too.
+func (c *NventoryClient) SetBaseContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// SetTimeout limits how long each request to the server may take, including
+// reading the response. Zero means no limit.
+func (c *NventoryClient) SetTimeout(timeout time.Duration) {
+	c.HttpClient.SetTimeout(timeout)
+}
+
+// SetTLSOptions sets how the server's certificate is verified, see TLSOptions.
+func (c *NventoryClient) SetTLSOptions(opts TLSOptions) error {
+	return c.HttpClient.SetTLSOptions(opts)
+}
+
+// SetAuthMethod sets how users log in to the server, see
+// HttpClient.SetAuthMethod.
+func (c *NventoryClient) SetAuthMethod(method string) error {
+	return c.HttpClient.SetAuthMethod(method)
+}
+
+// SetCredentialOptions sets where the password to log in with comes from, see
+// CredentialOptions.
+func (c *NventoryClient) SetCredentialOptions(opts CredentialOptions) {
+	c.HttpClient.SetCredentialOptions(opts, c.Input)
+}
+
+// SetProxy sets the proxy to reach the server through, see HttpClient.SetProxy.
+func (c *NventoryClient) SetProxy(proxy string) error {
+	return c.HttpClient.SetProxy(proxy)
+}
+
+// SetSSOServer sets the SSO server to log in to, see HttpClient.SetSSOServer.
+func (c *NventoryClient) SetSSOServer(ssoServer string) {
+	c.HttpClient.SetSSOServer(ssoServer)
+}
+
+// SetSSOLogoutURL sets where logging out ends the SSO session, see
+// HttpClient.SetSSOLogoutURL.
+func (c *NventoryClient) SetSSOLogoutURL(logoutURL string) {
+	c.HttpClient.SetSSOLogoutURL(logoutURL)
+}
+
+func (c *NventoryClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// withContext returns a copy of the client sending its requests with ctx. The
+// copy shares the logged in http clients and the subsystem names.
+func (c *NventoryClient) withContext(ctx context.Context) *NventoryClient {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// canceled returns an error of kind ErrCanceled once the context of the
+// client is done.
+func (c *NventoryClient) canceled() error {
+	if err := c.context().Err(); err != nil {
+		return wrapError(ErrCanceled, err, "Canceled: %v\n", err)
+	}
+	return nil
+}
+
+// confirm asks prompt on the input of the client, answered with no when the
+// client is canceled.
+func (c *NventoryClient) confirm(prompt string) bool {
+	return promptUserConfirmation(c.context(), prompt, c.Input)
+}
+
+func (f *NventoryClient) GetHttpClientFor(username string) (*http.Client, error) {
 	if f.HttpClient.httpClientMap == nil {
 		f.HttpClient.httpClientMap = make(map[string]*http.Client, 0)
 	}
@@ -93,37 +199,76 @@ func (f *NventoryClient) GetHttpClientFor(username string) *http.Client {
 	httpClient := f.HttpClient.httpClientMap[username]
 	// Check if client is already initialized.
 	if httpClient == nil {
-		h, err := f.HttpClient.newHttpClientFor(username, passwordCallback)
+		h, err := f.HttpClient.newHttpClientFor(f.context(), username)
 		if err != nil {
-			logger.Error.Printf("Unable to initialize HTTP Client: %v\n", err)
-			os.Exit(1)
+			logger.Debug.Printf("Unable to initialize HTTP Client: %v\n", err)
+			return nil, wrapError(ErrAuth, err, "Unable to log in as %v: %v\n", username, err)
 		}
 		f.HttpClient.httpClientMap[username] = h
-		return h
+		return h, nil
 	}
 	f.SetServer(f.HttpClient.GetServer())
-	return httpClient
+	return httpClient, nil
 }
 
-func (f *NventoryClient) GetObjects(object_type string, conditions Conditions, includes []string) (Result, error) {
-	i, err := f.GetAllSubsystemNames(object_type)
+// getResponse GETs u as username and returns the body of the response, or an
+// error of the kind matching its status.
+func (f *NventoryClient) getResponse(username string, u string) (string, error) {
+	client, err := f.GetHttpClientFor(username)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", wrapError(ErrUsage, err, "Invalid URL %v: %v\n", u, err)
+	}
+	resp, err := client.Do(req.WithContext(f.context()))
+	if err != nil {
+		return "", requestError(f.context(), err, u)
 	}
-	includes = Intersection(i, includes)
+	responseStr, err := readResponseBody(resp.Body)
+	if err != nil {
+		return "", wrapError(ErrServer, err, "Unable to read response body from %v: %v\n", u, err)
+	}
+	return responseStr, checkResponse(resp, u)
+}
+
+// GetObjectsContext is GetObjects with its requests canceled when ctx is done.
+func (f *NventoryClient) GetObjectsContext(ctx context.Context, object_type string, conditions Conditions, includes []string) (Result, error) {
+	return f.withContext(ctx).GetObjects(object_type, conditions, includes)
+}
 
+func (f *NventoryClient) GetObjects(object_type string, conditions Conditions, includes []string) (Result, error) {
+	if len(includes) > 0 {
+		i, err := f.GetAllSubsystemNames(object_type)
+		if err != nil {
+			return nil, err
+		}
+		includes = Intersection(i, includes)
+	}
+	return f.getObjects(object_type, conditions, includes)
+}
+
+// getObjects searches without checking includes against the subsystem names.
+func (f *NventoryClient) getObjects(object_type string, conditions Conditions, includes []string) (Result, error) {
 	u := f.getSearchUrl(object_type, conditions, includes)
 	logger.Debug.Println(fmt.Sprintf("URL: %v", u))
 
-	resp, _ := f.GetHttpClientFor(f.username).Get(u)
-
-	responseStr, err := readResponseBody(resp.Body)
+	responseStr, err := f.getResponse(f.username, u)
 	if err != nil {
-		log.Fatal("Unable to read response body.")
+		return nil, err
 	}
 
 	res, err := f.getFieldValue(responseStr)
-	return res, err
+	if err != nil {
+		return nil, err
+	}
+	return convertGraffiti(res), nil
+}
+
+// SetObjectsContext is SetObjects with its requests canceled when ctx is done.
+func (f *NventoryClient) SetObjectsContext(ctx context.Context, object_type string, conditions Conditions, includes []string, set map[string]string, login string, noPrompt bool) (string, error) {
+	return f.withContext(ctx).SetObjects(object_type, conditions, includes, set, login, noPrompt)
 }
 
 func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, includes []string, set map[string]string, login string, noPrompt bool) (string, error) {
@@ -135,23 +280,41 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 	u := f.getSearchUrl(object_type, conditions, includes)
 	logger.Debug.Println(fmt.Sprintf("Search URL: %v", u))
 
-	resp, _ := f.GetHttpClientFor(f.username).Get(u)
-
-	responseStr, err := readResponseBody(resp.Body)
+	responseStr, err := f.getResponse(f.username, u)
 	if err != nil {
-		log.Fatal("Unable to read response body.")
+		return "Unable to get objects.", err
 	}
 
 	res, err := GetResultsFromResponse(responseStr)
+	if err != nil {
+		return "Unable to get objects.", err
+	}
 
+	var resp *http.Response
 	numSuccess := 0
 
 	switch t := res.(type) {
 	case *ResultArray:
 		if len(t.Array) > 0 {
-			con := noPrompt || PromptUserConfirmation(fmt.Sprintf("This will update %v entry, continue?  [y/N]: ", len(t.Array)), f.Input)
+			if f.dryRun {
+				plan := make([]mutation, 0, len(t.Array))
+				for _, item := range t.Array {
+					if t2, ok := item.(*ResultMap); ok {
+						if id, ok := t2.Get("id").(*ResultValue); ok && id.Value != "" {
+							values := getSetValues(t2.ID(), set)
+							plan = append(plan, mutation{id: id.Value, name: getResultName(t2), method: "PUT", url: f.getSetUrl(object_type, id.Value, values.Encode()), values: values})
+						}
+					}
+				}
+				return printDryRun(plan), nil
+			}
+
+			con := noPrompt || f.confirm(fmt.Sprintf("This will update %v entry, continue?  [y/N]: ", len(t.Array)))
 			if con {
 				for _, item := range t.Array {
+					if err := f.canceled(); err != nil {
+						return fmt.Sprintf("%v out of %v update(s) succeeded before canceling.\n", numSuccess, len(t.Array)), err
+					}
 					switch t2 := item.(type) {
 					case *ResultMap:
 						idVal := t2.Get("id")
@@ -159,15 +322,7 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 
 						} else if id, ok := idVal.(*ResultValue); ok && id.Value != "" {
 							logger.Debug.Printf("Set: %v", set)
-							values := url.Values{}
-							for k, v := range set {
-								re, err := regexp.Compile(`[.+]`)
-								if err == nil && re.Match([]byte(k)) {
-									values.Set(k, v)
-								} else {
-									values.Set(t2.ID()+"["+k+"]", v)
-								}
-							}
+							values := getSetValues(t2.ID(), set)
 
 							u := f.getSetUrl(object_type, id.Value, values.Encode())
 							logger.Debug.Printf("Set URL: %v\n", u)
@@ -179,11 +334,11 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 								logger.Debug.Printf("PUT Request: %v", req)
 								isRedirect := true
 								err = nil
-								client := f.GetHttpClientFor(login)
+								client, err := f.GetHttpClientFor(login)
 								for isRedirect && err == nil {
 									logger.Debug.Printf("%v url: %V\n", req.Method, req.URL)
 									req, _ = http.NewRequest(req.Method, req.URL.String(), nil)
-									resp, err = client.Do(req)
+									resp, err = client.Do(req.WithContext(f.context()))
 									isRedirect = isRedirectResponse(resp)
 									if isRedirect {
 										logger.Debug.Printf("Redirecting to %v from %v\n", getHeaderLocation(resp), req.URL.String())
@@ -198,6 +353,9 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 									logger.Error.Printf("Error requesting PUT request for url: %v\nError: %v\n", u, err)
 								} else {
 									body, err := readResponseBody(resp.Body)
+									if err == nil {
+										err = checkResponse(resp, u)
+									}
 									if err == nil {
 										logger.Debug.Printf("Success Response Body:\n%v\n", body)
 										numSuccess++
@@ -210,11 +368,11 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 					}
 				}
 			} else {
-				return fmt.Sprintln("Cancelled"), nil
+				return fmt.Sprintln("Cancelled"), f.canceled()
 			}
 			msg := fmt.Sprintf("%v out of %v update(s) succeeded.\n", numSuccess, len(t.Array))
 			if numSuccess != len(t.Array) {
-				err = errors.New(fmt.Sprintf("%v out of %v update(s) failed.\n", len(t.Array)-numSuccess, len(t.Array)))
+				err = newError(ErrPartialUpdate, "%v out of %v update(s) failed.\n", len(t.Array)-numSuccess, len(t.Array))
 			}
 			return msg, err
 		}
@@ -230,18 +388,15 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 		name = set["name"]
 	}
 
-	con := noPrompt || PromptUserConfirmation(fmt.Sprintf("This will create new entry (%v), continue?  [y/N]: ", name), f.Input)
+	if f.dryRun {
+		values := getSetValues(singularize(object_type), set)
+		return printDryRun([]mutation{{name: name, method: "POST", url: f.getCreateUrl(object_type, values.Encode()), values: values}}), nil
+	}
+
+	con := noPrompt || f.confirm(fmt.Sprintf("This will create new entry (%v), continue?  [y/N]: ", name))
 	if con {
 		logger.Debug.Printf("Set: %v", set)
-		values := url.Values{}
-		for k, v := range set {
-			re, err := regexp.Compile(`[.+]`)
-			if err == nil && re.Match([]byte(k)) {
-				values.Set(k, v)
-			} else {
-				values.Set(singularize(object_type)+"["+k+"]", v)
-			}
-		}
+		values := getSetValues(singularize(object_type), set)
 
 		u := f.getCreateUrl(object_type, values.Encode())
 		logger.Debug.Printf("Create URL: %v\n", u)
@@ -253,10 +408,11 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 			logger.Debug.Printf("POST Request: %v", req)
 			isRedirect := true
 			err = nil
+			client, err := f.GetHttpClientFor(login)
 			for isRedirect && err == nil {
 				req, _ = http.NewRequest(req.Method, req.URL.String(), nil)
-				resp, err = f.GetHttpClientFor(login).Do(req)
-				logger.Debug.Printf("Response from %v:\n%v\n", req.URL.String(), resp)
+				resp, err = client.Do(req.WithContext(f.context()))
+				logger.Debug.Printf("Response from %v: %v\n", req.URL.String(), describeResponse(resp))
 				isRedirect = isRedirectResponse(resp)
 				if isRedirect {
 					logger.Debug.Printf("Redirecting to %v from %v\n", getHeaderLocation(resp), req.URL.String())
@@ -268,23 +424,214 @@ func (f *NventoryClient) SetObjects(object_type string, conditions Conditions, i
 			}
 
 			if err != nil {
-				logger.Error.Printf("Error requesting PUT request for url: %v\nError: %v\n", u, err)
-			} else {
-				body, err := readResponseBody(resp.Body)
-				if err == nil {
-					logger.Debug.Printf("Success Response Body:\n%v\n", body)
-					return fmt.Sprintf("Successfully created node (%v)\n", name), err
-				} else {
-					msg := fmt.Sprintf("Error: %v", err)
-					return msg, errors.New(msg)
-				}
+				logger.Error.Printf("Error requesting POST request for url: %v\nError: %v\n", u, err)
+				return fmt.Sprintf("No update was ran.\n"), requestError(f.context(), err, u)
+			}
+			body, err := readResponseBody(resp.Body)
+			if err != nil {
+				msg := fmt.Sprintf("Error: %v", err)
+				return msg, wrapError(ErrServer, err, "%v", msg)
+			}
+			if err := checkResponse(resp, u); err != nil {
+				logger.Debug.Printf("Error Response Body:\n%v\n", body)
+				return fmt.Sprintf("No update was ran.\n"), err
 			}
+			logger.Debug.Printf("Success Response Body:\n%v\n", body)
+			return fmt.Sprintf("Successfully created node (%v)\n", name), nil
 		}
 	}
 
+	if err == nil {
+		err = f.canceled()
+	}
 	return fmt.Sprintf("No update was ran.\n"), err
 }
 
+// DeleteObjects sends a DELETE for every object matched by conditions and
+// reports how many of them succeeded.
+func (f *NventoryClient) DeleteObjects(object_type string, conditions Conditions, includes []string, login string, noPrompt bool) (string, error) {
+	res, err := f.GetObjects(object_type, conditions, includes)
+	if err != nil {
+		return "Unable to get objects to delete.", err
+	}
+
+	t, ok := res.(*ResultArray)
+	if !ok || len(t.Array) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+
+	plan := make([]mutation, 0, len(t.Array))
+	for _, item := range t.Array {
+		t2, ok := item.(*ResultMap)
+		if !ok {
+			continue
+		}
+		id, ok := t2.Get("id").(*ResultValue)
+		if !ok || id.Value == "" {
+			logger.Error.Printf("%v has no id field, skipping delete.\n", getResultName(t2))
+			continue
+		}
+		plan = append(plan, mutation{id: id.Value, name: getResultName(t2), method: "DELETE", url: f.getDeleteUrl(object_type, id.Value)})
+	}
+
+	return f.applyMutations(plan, fmt.Sprintf("This will delete %v entry, continue?  [y/N]: ", len(plan)), login, noPrompt, "deletion")
+}
+
+// applyMutations sends every request in plan as login once the user confirms
+// with prompt, or only prints the plan on a dry run. verb names one request in
+// the summary, e.g. "3 out of 4 deletion(s) succeeded."
+func (f *NventoryClient) applyMutations(plan []mutation, prompt string, login string, noPrompt bool, verb string) (string, error) {
+	if f.dryRun {
+		return printDryRun(plan), nil
+	}
+	if len(plan) == 0 {
+		return fmt.Sprintln("Nothing to do."), nil
+	}
+
+	con := noPrompt || f.confirm(prompt)
+	if !con {
+		return fmt.Sprintln("Cancelled"), f.canceled()
+	}
+
+	numSuccess := 0
+	for _, m := range plan {
+		if err := f.canceled(); err != nil {
+			return fmt.Sprintf("%v out of %v %v(s) succeeded before canceling.\n", numSuccess, len(plan), verb), err
+		}
+		logger.Debug.Printf("%v URL: %v\n", m.method, m.url)
+
+		resp, err := f.sendRequest(m.method, m.url, login)
+		if err != nil {
+			logger.Error.Printf("Error requesting %v request for url: %v\nError: %v\n", m.method, m.url, err)
+			continue
+		}
+		body, err := readResponseBody(resp.Body)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			logger.Debug.Printf("Success Response Body:\n%v\n", body)
+			numSuccess++
+		} else {
+			logger.Error.Printf("%v to %v failed for %v (%v):\n%v\n", m.method, m.url, m.name, resp.Status, body)
+		}
+	}
+
+	var err error
+	msg := fmt.Sprintf("%v out of %v %v(s) succeeded.\n", numSuccess, len(plan), verb)
+	if numSuccess != len(plan) {
+		err = newError(ErrPartialUpdate, "%v out of %v %v(s) failed.\n", len(plan)-numSuccess, len(plan), verb)
+	}
+	return msg, err
+}
+
+// sendRequest issues a body-less request as login, following redirects by hand
+// the same way SetObjects does for PUT.
+func (f *NventoryClient) sendRequest(method, u, login string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	client, err := f.GetHttpClientFor(login)
+	isRedirect := true
+	for isRedirect && err == nil {
+		logger.Debug.Printf("%v url: %v\n", req.Method, req.URL)
+		req, _ = http.NewRequest(req.Method, req.URL.String(), nil)
+		resp, err = client.Do(req.WithContext(f.context()))
+		isRedirect = isRedirectResponse(resp)
+		if isRedirect {
+			logger.Debug.Printf("Redirecting to %v from %v\n", getHeaderLocation(resp), req.URL.String())
+			loc, perr := url.Parse(getHeaderLocation(resp))
+			if perr != nil {
+				return resp, perr
+			}
+			req.URL = loc
+		}
+	}
+	return resp, err
+}
+
+// getSetValues converts --set pairs to form values, nesting the keys under
+// prefix (e.g. avail_space => node[avail_space]). Keys with a . or a + in
+// them are sent as they are.
+func getSetValues(prefix string, set map[string]string) url.Values {
+	values := url.Values{}
+	for k, v := range set {
+		re, err := regexp.Compile(`[.+]`)
+		if err == nil && re.Match([]byte(k)) {
+			values.Set(k, v)
+		} else {
+			values.Set(prefix+"["+k+"]", v)
+		}
+	}
+	return values
+}
+
+// mutation describes a single write request, used to report what --dry-run
+// would have sent.
+type mutation struct {
+	id     string
+	name   string
+	method string
+	url    string
+	values url.Values
+}
+
+func (m mutation) String() string {
+	result := fmt.Sprintf("%v %v\n", m.method, m.url)
+	if m.id != "" {
+		result += fmt.Sprintf("  id: %v\n", m.id)
+	}
+	result += fmt.Sprintf("  name: %v\n", m.name)
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		result += fmt.Sprintf("  %v=%v\n", k, m.values.Get(k))
+	}
+	return result
+}
+
+func printDryRun(plan []mutation) string {
+	result := fmt.Sprintf("Dry run: %v request(s) would be sent, no changes made.\n", len(plan))
+	for _, m := range plan {
+		result += m.String()
+	}
+	return result
+}
+
+// getResultMaps runs a search and returns the matched objects.
+func (f *NventoryClient) getResultMaps(object_type string, conditions Conditions, includes []string) ([]*ResultMap, error) {
+	res, err := f.GetObjects(object_type, conditions, includes)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*ResultMap, 0)
+	if t, ok := res.(*ResultArray); ok {
+		for _, item := range t.Array {
+			if t2, ok := item.(*ResultMap); ok {
+				result = append(result, t2)
+			}
+		}
+	}
+	return result, nil
+}
+
+func getResultValue(r *ResultMap, key string) string {
+	if v, ok := r.Get(key).(*ResultValue); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func getResultName(r *ResultMap) string {
+	if name, ok := r.Get("name").(*ResultValue); ok {
+		return name.Value
+	}
+	return r.Name
+}
+
 func singularize(plural string) string {
 	if singular := regexp.MustCompile(`(.*s)es$`).FindAllStringSubmatch(plural, -1); len(singular) > 0 {
 		// ip_address(es), status(es)
@@ -297,6 +644,12 @@ func singularize(plural string) string {
 	return plural
 }
 
+// GetAllFieldsContext is GetAllFields with its requests canceled when ctx is
+// done.
+func (f *NventoryClient) GetAllFieldsContext(ctx context.Context, object_type string, command map[string][]string, includes []string, flags []string) (Result, error) {
+	return f.withContext(ctx).GetAllFields(object_type, command, includes, flags)
+}
+
 func (f *NventoryClient) GetAllFields(object_type string, command map[string][]string, includes []string, flags []string) (Result, error) {
 	fields, err := f.GetAllSubsystemNames(object_type)
 	if err != nil {
@@ -304,38 +657,48 @@ func (f *NventoryClient) GetAllFields(object_type string, command map[string][]s
 	}
 	u := f.getSearchUrl(object_type, command, fields)
 
-	resp, _ := f.GetHttpClientFor(f.username).Get(u)
-	f.SetServer(f.HttpClient.GetServer())
-
 	logger.Debug.Println(fmt.Sprintf("URL: %v", u))
 
-	responseStr, err := readResponseBody(resp.Body)
+	responseStr, err := f.getResponse(f.username, u)
+	f.SetServer(f.HttpClient.GetServer())
 	if err != nil {
-		log.Fatal("Unable to read response body.")
+		return nil, err
 	}
 
-	return GetResultsFromResponse(responseStr)
+	res, err := GetResultsFromResponse(responseStr)
+	if err != nil {
+		return nil, err
+	}
+	return convertGraffiti(res), nil
+}
+
+// GetAllSubsystemNamesContext is GetAllSubsystemNames with its request
+// canceled when ctx is done.
+func (f *NventoryClient) GetAllSubsystemNamesContext(ctx context.Context, objectType string) ([]string, error) {
+	return f.withContext(ctx).GetAllSubsystemNames(objectType)
 }
 
 func (f *NventoryClient) GetAllSubsystemNames(objectType string) ([]string, error) {
 	var err error
-	if len(f.subsystemNames) == 0 {
+	if f.subsystemNames == nil {
+		f.subsystemNames = make(map[string][]string, 0)
+	}
+	if len(f.subsystemNames[objectType]) == 0 {
 		// query http://opsdb.wc1.example.com/nodes/field_names.xml
 		u := fmt.Sprintf("%v/%v/field_names.xml", f.GetServer(), objectType)
 
 		// store search_shortcuts
-		resp, err := f.GetHttpClientFor(f.username).Get(u)
+		responseStr, err := f.getResponse(f.username, u)
 		if err != nil {
-			return f.subsystemNames, err
+			return f.subsystemNames[objectType], err
 		}
-		responseStr, err := readResponseBody(resp.Body)
-		if err != nil {
-			log.Fatal("Unable to read response body.")
+		if _, err := search_shortcuts.SaveFieldShortcuts(responseStr, "/field_names", "field_name", []string{}...); err != nil && ErrorKind(err) == ErrParse {
+			return f.subsystemNames[objectType], err
 		}
-		search_shortcuts.SaveFieldShortcuts(responseStr, "/field_names", "field_name", []string{}...)
-		f.subsystemNames, err = f.getSubsystemNamesFromResponse(responseStr)
+		f.subsystemNames[objectType], err = f.getSubsystemNamesFromResponse(responseStr)
+		return f.subsystemNames[objectType], err
 	}
-	return f.subsystemNames, err
+	return f.subsystemNames[objectType], err
 }
 
 func (f *NventoryClient) getSubsystemNamesFromResponse(response string) ([]string, error) {
@@ -343,7 +706,7 @@ func (f *NventoryClient) getSubsystemNamesFromResponse(response string) ([]strin
 
 	d, err := libxml2.ParseString(response)
 	if err != nil {
-		log.Fatal("Unable to parse response as xml:\n%v", response)
+		return nil, wrapError(ErrParse, err, "Unable to parse response as xml:\n%v\n", response)
 	}
 	xPathResult, err := d.Find("/field_names")
 
@@ -367,43 +730,23 @@ func (f *NventoryClient) getSubsystemNamesFromResponse(response string) ([]strin
 		result = append(result, k)
 	}
 	if !found {
-		return result, errors.New("No matching objects\n")
+		return result, newError(ErrNotFound, "No matching objects\n")
 	}
 	return result, nil
 }
 
 func (f *NventoryClient) getSearchUrl(object_type string, searchCommand Conditions, includes []string) string {
-	// start organizing commands issued
-	values := url.Values{}
-	for k, v := range searchCommand {
-		values = mergeMapOfStringArrays(values, Separate(v, k))
-	}
-
-	for _, f := range includes {
-		m := make([]string, 0)
-
-		var fieldsRegex = regexp.MustCompile(`([^[]+)\[.+\]`)
-		if fieldsRegex.MatchString(f) {
-			// field[subfield]
-			fieldName := fieldsRegex.FindAllStringSubmatch(f, -1)
-			val := []string{""}
-			if strings.Contains(f, "[tags]") {
-				val = append(val, "tags")
-			}
-			m = append(m, fmt.Sprintf("includes[%v]=%v", fieldName[0][1], val))
-		} else {
-			// field
-			m = append(m, fmt.Sprintf("includes[%v]=", f))
-		}
-	}
-
-	return fmt.Sprintf("%v/%v.xml?%v", f.GetServer(), object_type, values.Encode())
+	return getSearchUrl(f.GetServer(), object_type, searchCommand, includes)
 }
 
 func (f *NventoryClient) getSetUrl(object_type string, id string, query string) string {
 	return fmt.Sprintf("%v/%v/%v.xml?%v", f.GetServer(), object_type, id, query)
 }
 
+func (f *NventoryClient) getDeleteUrl(object_type string, id string) string {
+	return fmt.Sprintf("%v/%v/%v.xml", f.GetServer(), object_type, id)
+}
+
 func (f *NventoryClient) getCreateUrl(object_type string, query string) string {
 	return fmt.Sprintf("%v/%v.xml?%v", f.GetServer(), object_type, query)
 }
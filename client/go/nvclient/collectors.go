@@ -0,0 +1,546 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*******
+ * Collector gathers one kind of facts for --register, e.g. the CPUs or the
+ * NICs of the machine. Collectors read files and run commands through the
+ * HostInfo they're given, so they can be tested against a fixture tree.
+ *******/
+type Collector interface {
+	// Name identifies the collector in error messages, e.g. "cpu".
+	Name() string
+	// Collect adds the node fields it finds to data.
+	Collect(h *HostInfo, data map[string]string) error
+}
+
+var siteCollectors = make([]Collector, 0)
+
+// AddCollector adds a collector of site-specific facts to every HostInfo
+// created by NewHostInfo afterwards. Its facts override the default ones.
+func AddCollector(c Collector) {
+	siteCollectors = append(siteCollectors, c)
+}
+
+// DefaultCollectors returns the collectors --register uses, leaving out the
+// switch port lookup and storage detection when asked to.
+func DefaultCollectors(noSwitchport bool, noStorage bool) []Collector {
+	collectors := []Collector{
+		&OSReleaseCollector{},
+		&CPUCollector{},
+		&MemoryCollector{},
+		&DMICollector{},
+		&NICCollector{NoSwitchport: noSwitchport},
+	}
+	if !noStorage {
+		collectors = append(collectors, &StorageCollector{})
+	}
+	return append(collectors, &KVMCollector{})
+}
+
+// OSReleaseCollector reports the architecture, the kernel version and the
+// distribution from /etc/os-release. Older distributions like CentOS 6 have
+// no /etc/os-release, they're reported without a distribution.
+type OSReleaseCollector struct{}
+
+func (c *OSReleaseCollector) Name() string { return "os release" }
+
+func (c *OSReleaseCollector) Collect(h *HostInfo, data map[string]string) error {
+	if arch := strings.TrimSpace(h.run("uname", "-m")); arch != "" {
+		data["operating_system[architecture]"] = arch
+	}
+	if kernel := h.readFile("/proc/sys/kernel/osrelease"); kernel != "" {
+		data["kernel_version"] = kernel
+	}
+
+	content, err := h.ReadFile("/etc/os-release")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	release := parseKeyValues(content, "=")
+	if release["NAME"] != "" {
+		data["operating_system[variant]"] = release["NAME"]
+		data["operating_system[version_number]"] = release["VERSION_ID"]
+	}
+	return nil
+}
+
+var processorRegex = regexp.MustCompile(`^(\S+)\s(.+)$`)
+var processorSpeedRegex = regexp.MustCompile(`^(.+?\S)\s+(?:@\s+)?([\d.]+.Hz)$`)
+
+// CPUCollector counts the processors the OS sees, the physical processors and
+// their cores in /proc/cpuinfo, and splits the model name the same way the
+// ruby client does, e.g. "Intel(R) Xeon(R) CPU E5-2650 0 @ 2.00GHz".
+type CPUCollector struct{}
+
+func (c *CPUCollector) Name() string { return "cpu" }
+
+func (c *CPUCollector) Collect(h *HostInfo, data map[string]string) error {
+	content, err := h.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return err
+	}
+	processors := 0
+	modelName := ""
+	physicalIDs := make(map[string]bool, 0)
+	cores := make(map[string]bool, 0)
+	physicalID, coreID := "", ""
+	for _, line := range strings.Split(content, "\n") {
+		pair := strings.SplitN(line, ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+		switch key {
+		case "processor":
+			processors++
+			physicalID, coreID = "", ""
+		case "model name":
+			if modelName == "" {
+				modelName = value
+			}
+		case "physical id":
+			physicalID = value
+			physicalIDs[value] = true
+		case "core id":
+			coreID = value
+		}
+		if physicalID != "" && coreID != "" {
+			cores[physicalID+":"+coreID] = true
+		}
+	}
+
+	data["os_processor_count"] = strconv.Itoa(processors)
+	if len(physicalIDs) > 0 {
+		data["processor_count"] = strconv.Itoa(len(physicalIDs))
+		data["processor_core_count"] = strconv.Itoa(len(cores))
+	}
+	if m := processorRegex.FindStringSubmatch(modelName); m != nil {
+		data["processor_manufacturer"] = strings.Replace(m[1], "(R)", "", -1)
+		model := m[2]
+		if s := processorSpeedRegex.FindStringSubmatch(model); s != nil {
+			model = s[1]
+			data["processor_speed"] = s[2]
+		}
+		data["processor_model"] = model
+	}
+	return nil
+}
+
+// MemoryCollector reports memory and swap from /proc/meminfo like facter
+// does, e.g. "15.58 GB".
+type MemoryCollector struct{}
+
+func (c *MemoryCollector) Name() string { return "memory" }
+
+func (c *MemoryCollector) Collect(h *HostInfo, data map[string]string) error {
+	content, err := h.ReadFile("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	meminfo := parseKeyValues(content, ":")
+	if kb := parseKB(meminfo["MemTotal"]); kb > 0 {
+		data["os_memory"] = scaleKB(kb)
+	}
+	if kb := parseKB(meminfo["SwapTotal"]); kb > 0 {
+		data["swap"] = scaleKB(kb)
+	}
+	return nil
+}
+
+func parseKB(s string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(s), " kB"), 10, 64)
+	return n
+}
+
+func scaleKB(kb int64) string {
+	value := float64(kb)
+	units := []string{"kB", "MB", "GB", "TB"}
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f %v", value, units[i])
+}
+
+var dimmFormFactors = map[string]bool{"DIMM": true, "FB-DIMM": true, "SODIMM": true}
+
+// DMICollector reports the hardware profile, serial number and installed
+// memory from dmidecode.
+type DMICollector struct{}
+
+func (c *DMICollector) Name() string { return "dmi" }
+
+func (c *DMICollector) Collect(h *HostInfo, data map[string]string) error {
+	data["hardware_profile[manufacturer]"] = "Unknown"
+	data["hardware_profile[model]"] = "Unknown"
+	dmi := h.DmiData()
+	if len(dmi) == 0 {
+		return errors.New("No dmidecode output.\n")
+	}
+	if v := dmi.Get("System Information", "Manufacturer"); v != "" {
+		data["hardware_profile[manufacturer]"] = v
+		data["hardware_profile[model]"] = dmi.Get("System Information", "Product Name")
+	}
+	if v := dmi.Get("System Information", "Serial Number"); v != "" {
+		data["serial_number"] = v
+	}
+
+	// Like the ruby client, only count DIMMs, other memory devices are
+	// usually little chunks of memory used by the hardware itself.
+	physicalMemory := 0
+	for _, dev := range dmi["Memory Device"] {
+		formFactor := dev["Form Factor"]
+		if !dimmFormFactors[formFactor] && !(formFactor == "<OUT OF SPEC>" && strings.Contains(dev["Locator"], "DIMM")) {
+			continue
+		}
+		fs := strings.Fields(dev["Size"])
+		if len(fs) != 2 {
+			continue
+		}
+		size, err := strconv.Atoi(fs[0])
+		if err != nil {
+			continue
+		}
+		switch fs[1] {
+		case "MB":
+			physicalMemory += size
+		case "GB":
+			physicalMemory += size * 1024
+		}
+	}
+	if physicalMemory > 0 {
+		data["physical_memory"] = scaleKB(int64(physicalMemory) * 1024)
+	}
+	return nil
+}
+
+var ipAddrRegex = regexp.MustCompile(`^\d+:\s+(\S+)\s+inet\s+(\S+)`)
+var portNameRegex = regexp.MustCompile(`^[A-Za-z]+`)
+
+// NICCollector reports every interface in /sys/class/net except the
+// loopback, with its MAC address, link settings and IPv4 address from
+// `ip -o -4 addr show`. Unless NoSwitchport is set, it also reports the
+// switch and port each NIC is plugged into from the LLDP neighbors saved by
+// systemd-networkd, or else the ones lldpctl knows about. The NIC data is
+// marked authoritative so the server removes NICs we don't report.
+type NICCollector struct {
+	NoSwitchport bool
+}
+
+func (c *NICCollector) Name() string { return "network interfaces" }
+
+func (c *NICCollector) Collect(h *HostInfo, data map[string]string) error {
+	entries, err := ioutil.ReadDir(h.Path("/sys/class/net"))
+	if err != nil {
+		return err
+	}
+
+	nics := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		nic := entry.Name()
+		if nic == "lo" {
+			continue
+		}
+		nics = append(nics, nic)
+		prefix := fmt.Sprintf("network_interfaces[%v]", nic)
+		dir := filepath.Join("/sys/class/net", nic)
+		data[prefix+"[name]"] = nic
+		if mac := h.readFile(filepath.Join(dir, "address")); mac != "" {
+			data[prefix+"[hardware_address]"] = mac
+		}
+		if h.readFile(filepath.Join(dir, "type")) == "1" {
+			data[prefix+"[interface_type]"] = "Ethernet"
+		}
+		if h.readFile(filepath.Join(dir, "operstate")) == "up" {
+			data[prefix+"[up]"] = "1"
+		}
+		if speed := h.readFile(filepath.Join(dir, "speed")); speed != "" && !strings.HasPrefix(speed, "-") {
+			data[prefix+"[speed]"] = speed
+		}
+		switch h.readFile(filepath.Join(dir, "duplex")) {
+		case "full":
+			data[prefix+"[full_duplex]"] = "1"
+		case "half":
+			data[prefix+"[full_duplex]"] = "0"
+		}
+	}
+
+	// Only the first address of each interface is reported, like facter does.
+	for _, line := range strings.Split(h.run("ip", "-o", "-4", "addr", "show"), "\n") {
+		m := ipAddrRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		prefix := fmt.Sprintf("network_interfaces[%v][ip_addresses][0]", m[1])
+		if _, ok := data[fmt.Sprintf("network_interfaces[%v][name]", m[1])]; !ok {
+			continue
+		}
+		if _, ok := data[prefix+"[address]"]; ok {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(m[2])
+		if err != nil {
+			continue
+		}
+		data[prefix+"[address]"] = ip.String()
+		data[prefix+"[address_type]"] = "ipv4"
+		data[prefix+"[netmask]"] = net.IP(ipNet.Mask).String()
+	}
+
+	if !c.NoSwitchport {
+		c.collectSwitchPorts(h, nics, data)
+	}
+	data["network_interfaces[authoritative]"] = "true"
+	return nil
+}
+
+// networkdLLDPDir is where systemd-networkd saves the LLDP frames received
+// on each interface, in a file named after the interface index.
+const networkdLLDPDir = "/run/systemd/netif/lldp"
+
+// collectSwitchPorts adds the switch and port of each NIC from the LLDP
+// frames in networkdLLDPDir, or from `lldpctl -f keyvalue` for the NICs
+// without one. The server looks ports up as Gi<port>, so the interface type
+// is stripped from names like GigabitEthernet1/0/12.
+func (c *NICCollector) collectSwitchPorts(h *HostInfo, nics []string, data map[string]string) {
+	missing := false
+	for _, nic := range nics {
+		ifindex := h.readFile(filepath.Join("/sys/class/net", nic, "ifindex"))
+		frames, err := ioutil.ReadFile(h.Path(filepath.Join(networkdLLDPDir, ifindex)))
+		if ifindex == "" || err != nil {
+			missing = true
+			continue
+		}
+		if switchName, port := parseLLDPFrames(frames); switchName != "" {
+			setSwitchPort(data, nic, switchName, port)
+		} else {
+			missing = true
+		}
+	}
+	if !missing {
+		return
+	}
+
+	neighbors := parseKeyValues(h.run("lldpctl", "-f", "keyvalue"), "=")
+	for _, nic := range nics {
+		prefix := fmt.Sprintf("network_interfaces[%v]", nic)
+		if _, ok := data[prefix+"[switch]"]; ok {
+			continue
+		}
+		if switchName := neighbors["lldp."+nic+".chassis.name"]; switchName != "" {
+			setSwitchPort(data, nic, switchName, neighbors["lldp."+nic+".port.ifname"])
+		}
+	}
+}
+
+func setSwitchPort(data map[string]string, nic, switchName, port string) {
+	prefix := fmt.Sprintf("network_interfaces[%v]", nic)
+	data[prefix+"[switch]"] = switchName
+	if port != "" {
+		data[prefix+"[port]"] = portNameRegex.ReplaceAllString(port, "")
+	}
+}
+
+// LLDP TLV types, see IEEE 802.1AB.
+const (
+	lldpTLVEnd             = 0
+	lldpTLVPortID          = 2
+	lldpTLVPortDescription = 4
+	lldpTLVSystemName      = 5
+
+	lldpPortIDInterfaceName = 5
+	lldpPortIDLocal         = 7
+)
+
+// parseLLDPFrames returns the system name and port of the first neighbor in
+// a file of systemd-networkd, where each frame received is preceded by its
+// size as a little endian 64 bit integer. The port is the port id when it's
+// an interface name, else the port description.
+func parseLLDPFrames(frames []byte) (string, string) {
+	const etherHeaderSize = 14
+	for len(frames) >= 8 {
+		size := binary.LittleEndian.Uint64(frames)
+		frames = frames[8:]
+		if size > uint64(len(frames)) {
+			break
+		}
+		frame := frames[:size]
+		frames = frames[size:]
+		if len(frame) < etherHeaderSize {
+			continue
+		}
+
+		switchName, portID, portDescription := "", "", ""
+		tlvs := frame[etherHeaderSize:]
+		for len(tlvs) >= 2 {
+			tlvType := tlvs[0] >> 1
+			length := int(tlvs[0]&1)<<8 | int(tlvs[1])
+			tlvs = tlvs[2:]
+			if tlvType == lldpTLVEnd || length > len(tlvs) {
+				break
+			}
+			value := tlvs[:length]
+			tlvs = tlvs[length:]
+			switch tlvType {
+			case lldpTLVPortID:
+				if length > 1 && (value[0] == lldpPortIDInterfaceName || value[0] == lldpPortIDLocal) {
+					portID = string(value[1:])
+				}
+			case lldpTLVPortDescription:
+				portDescription = string(value)
+			case lldpTLVSystemName:
+				switchName = string(value)
+			}
+		}
+		if switchName != "" {
+			if portID == "" {
+				portID = portDescription
+			}
+			return switchName, portID
+		}
+	}
+	return "", ""
+}
+
+var dfRegex = regexp.MustCompile(`\s+\d+\s+(\d+)\s+(\d+)\s+\d+%\s+/(home)?$`)
+var autofsRegex = regexp.MustCompile(`^(\w[\w\S]+)\s+\S+\s+(\w[\w\S]+):(\S+)`)
+var fstabNfsRegex = regexp.MustCompile(`^(\w[\w\S]+):(\S+)\s+(\S+)\s+nfs`)
+
+// StorageCollector reports the used and available space of / and /home, the
+// nfs volumes mounted through autofs or /etc/fstab and the ones served
+// through /etc/exports.
+type StorageCollector struct{}
+
+func (c *StorageCollector) Name() string { return "storage" }
+
+func (c *StorageCollector) Collect(h *HostInfo, data map[string]string) error {
+	if df := h.run("df", "-k"); df != "" {
+		used, avail := 0, 0
+		for _, line := range strings.Split(df, "\n") {
+			if m := dfRegex.FindStringSubmatch(line); m != nil {
+				u, _ := strconv.Atoi(m[1])
+				a, _ := strconv.Atoi(m[2])
+				used += u
+				avail += a
+			}
+		}
+		data["used_space"] = strconv.Itoa(used)
+		data["avail_space"] = strconv.Itoa(avail)
+	}
+
+	autofs, _ := filepath.Glob(h.Path("/etc/auto[._]*"))
+	for _, file := range autofs {
+		config := filepath.Join("/etc", filepath.Base(file))
+		for _, line := range strings.Split(h.readFile(config), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			if m := autofsRegex.FindStringSubmatch(line); m != nil {
+				addMountedVolume(data, "/mnt/"+m[1], config, m[2], m[3])
+			}
+		}
+	}
+	for _, line := range strings.Split(h.readFile("/etc/fstab"), "\n") {
+		if m := fstabNfsRegex.FindStringSubmatch(line); m != nil {
+			addMountedVolume(data, m[3], "/etc/fstab", m[1], m[2])
+		}
+	}
+
+	for _, line := range strings.Split(h.readFile("/etc/exports"), "\n") {
+		fs := strings.Fields(line)
+		if len(fs) < 2 || strings.HasPrefix(fs[0], "#") {
+			continue
+		}
+		data[fmt.Sprintf("volumes[served][%v][config]", fs[0])] = "/etc/exports"
+		data[fmt.Sprintf("volumes[served][%v][type]", fs[0])] = "nfs"
+	}
+	return nil
+}
+
+func addMountedVolume(data map[string]string, mnt, config, server, volume string) {
+	prefix := fmt.Sprintf("volumes[mounted][%v]", mnt)
+	data[prefix+"[config]"] = config
+	data[prefix+"[volume_server]"] = server
+	data[prefix+"[volume]"] = volume
+	data[prefix+"[type]"] = "nfs"
+}
+
+var virshListRegex = regexp.MustCompile(`^\s*(\d+|-)\s+(\S+)\s+\S+`)
+var sourceFileRegex = regexp.MustCompile(`source file='([^']+)'`)
+
+// KVMCollector reports whether this is a virtual machine or a hypervisor,
+// and the image size of each guest of a KVM host as listed by virsh.
+type KVMCollector struct{}
+
+func (c *KVMCollector) Name() string { return "kvm" }
+
+func (c *KVMCollector) Collect(h *HostInfo, data map[string]string) error {
+	dmi := h.DmiData()
+	model := dmi.Get("System Information", "Product Name")
+	manufacturer := dmi.Get("System Information", "Manufacturer")
+	modules := "\n" + h.readFile("/proc/modules")
+	switch {
+	case strings.Contains(model, "VMware"):
+		data["virtualmode"], data["virtualarch"] = "guest", "vmware"
+	case strings.Contains(model, "KVM") || manufacturer == "QEMU":
+		data["virtualmode"], data["virtualarch"] = "guest", "kvm"
+	case strings.Contains(h.readFile("/proc/xen/capabilities"), "control_d"):
+		data["virtualmode"], data["virtualarch"] = "host", "xen"
+	case strings.Contains(modules, "\nxen"):
+		data["virtualmode"], data["virtualarch"] = "guest", "xen"
+	case strings.Contains(modules, "\nkvm"):
+		data["virtualmode"], data["virtualarch"] = "host", "kvm"
+		c.collectGuests(h, data)
+	}
+	return nil
+}
+
+func (c *KVMCollector) collectGuests(h *HostInfo, data map[string]string) {
+	for _, line := range strings.Split(h.run("virsh", "list", "--all"), "\n") {
+		m := virshListRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		guest := m[2]
+		for _, xmlLine := range strings.Split(h.run("virsh", "dumpxml", guest), "\n") {
+			s := sourceFileRegex.FindStringSubmatch(xmlLine)
+			if s == nil {
+				continue
+			}
+			// nVentory expects the image size in KB
+			if fi, err := os.Stat(h.Path(s[1])); err == nil {
+				data[fmt.Sprintf("vmguest[%v][vmimg_size]", guest)] = strconv.FormatInt(fi.Size()/1024, 10)
+			}
+			break
+		}
+	}
+}
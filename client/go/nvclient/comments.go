@@ -0,0 +1,53 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// AddComment attaches the comment to every object matched by conditions. The
+// comments are posted as login, which the server records as their author.
+func (f *NventoryClient) AddComment(object_type string, conditions Conditions, comment string, login string, noPrompt bool) (string, error) {
+	if comment == "" {
+		return "", newError(ErrUsage, "Comment can't be empty.\n")
+	}
+	objects, err := f.getResultMaps(object_type, conditions, []string{})
+	if err != nil {
+		return "Unable to get objects.", err
+	}
+	if len(objects) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+
+	commentableType := camelize(singularize(object_type))
+	plan := make([]mutation, 0, len(objects))
+	for _, obj := range objects {
+		values := url.Values{}
+		values.Set("comment[comment]", comment)
+		values.Set("comment[commentable_id]", getResultValue(obj, "id"))
+		values.Set("comment[commentable_type]", commentableType)
+		plan = append(plan, mutation{
+			name:   getResultName(obj),
+			method: "POST",
+			url:    f.getCreateUrl("comments", values.Encode()),
+			values: values,
+		})
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will add a comment as %v to %v object(s), continue?  [y/N]: ", login, len(objects)), login, noPrompt, "comment")
+	return fmt.Sprintf("Commenting as %v.\n", login) + msg, err
+}
@@ -0,0 +1,143 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigFiles returns the nventory.conf files read by the ruby client, in the
+// order they are read.
+func ConfigFiles() []string {
+	return []string{"/etc/nventory.conf", filepath.Join(os.Getenv("HOME"), ".nventory.conf")}
+}
+
+// firstValueWins lists the settings the ruby client takes from the first file
+// setting them instead of the last one.
+var firstValueWins = map[string]bool{"sso_server": true, "proxy_server": true}
+
+/*******
+ * ReadConfigFiles reads the `key = value` settings of nventory.conf files like
+ * the ruby client does: blank lines and lines starting with # are skipped, and
+ * a file overrides the settings of the files before it, except sso_server and
+ * proxy_server which keep the first value found. Files that don't exist are
+ * skipped. It returns the settings and the file each one came from.
+ *******/
+func ReadConfigFiles(files ...string) (map[string]string, map[string]string, error) {
+	settings := make(map[string]string, 0)
+	sources := make(map[string]string, 0)
+	for _, filename := range files {
+		f, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return settings, sources, wrapError(ErrUsage, err, "Unable to read config file %v: %v\n", filename, err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pair := strings.SplitN(line, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			key, value := strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+			if _, ok := settings[key]; ok && firstValueWins[key] {
+				continue
+			}
+			settings[key] = value
+			sources[key] = filename
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return settings, sources, wrapError(ErrUsage, err, "Unable to read config file %v: %v\n", filename, err)
+		}
+	}
+	return settings, sources, nil
+}
+
+// ProfileNames returns the names of the profiles defined in settings by keys
+// like profiles.<name>.server, sorted.
+func ProfileNames(settings map[string]string) []string {
+	set := make(map[string]bool, 0)
+	for key := range settings {
+		if fields := strings.SplitN(key, ".", 3); len(fields) == 3 && fields[0] == "profiles" {
+			set[fields[1]] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileSettings returns the settings of the profile name, e.g. server for
+// profiles.<name>.server. Profile names aren't case sensitive.
+func ProfileSettings(settings map[string]string, name string) (map[string]string, error) {
+	prefix := "profiles." + strings.ToLower(name) + "."
+	profile := make(map[string]string, 0)
+	for key, value := range settings {
+		if strings.HasPrefix(strings.ToLower(key), prefix) {
+			profile[key[len(prefix):]] = value
+		}
+	}
+	if len(profile) == 0 {
+		return nil, newError(ErrUsage, "Unknown profile %v, known profiles: %v\n", name, strings.Join(ProfileNames(settings), ", "))
+	}
+	return profile, nil
+}
+
+// SetConfigFileValue sets key to value in a nventory.conf file, replacing the
+// line setting it or adding one, and keeping the rest of the file as is.
+func SetConfigFileValue(filename string, key string, value string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return wrapError(ErrUsage, err, "Unable to read config file %v: %v\n", filename, err)
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	lines := make([]string, 0)
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	found := false
+	for i, line := range lines {
+		pair := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(pair) == 2 && strings.TrimSpace(pair[0]) == key && !found {
+			lines[i] = key + " = " + value
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, key+" = "+value)
+	}
+
+	err = writeFileAtomic(filename, []byte(strings.Join(lines, "\n")+"\n"), mode)
+	return wrapError(ErrUsage, err, "Unable to write config file %v: %v\n", filename, err)
+}
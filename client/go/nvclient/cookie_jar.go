@@ -0,0 +1,352 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// The formats of the cookie file, chosen with the cookie_format setting.
+const (
+	// CookieFormatJSON keeps a JSON object per cookie, with the nventory
+	// server it was saved for.
+	CookieFormatJSON = "json"
+	// CookieFormatRuby keeps the Set-Cookie lines the ruby and perl clients
+	// read, so they share the session. They don't keep the servers apart.
+	CookieFormatRuby = "ruby"
+)
+
+// rubyCookieLine matches the lines of the ruby client's cookie file, as in
+// its parse_cookie. The perl client writes Set-Cookie3 lines.
+var rubyCookieLine = regexp.MustCompile(`^Set-Cookie\d?: (.+=.+)`)
+
+// rubyCookieTimeFormats are the expires formats found in the cookie files of
+// the ruby and perl clients.
+var rubyCookieTimeFormats = []string{
+	"Mon, 02-Jan-2006 15:04:05 GMT",
+	time.RFC1123,
+	"Mon, 02 Jan 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05Z",
+}
+
+/*******
+ * cookieJar is the cookie file of a user, kept between runs. The cookies are
+ * stored one per line in the format of the cookie_format setting, with the
+ * nventory server they were saved for in the JSON format, so a cookie file
+ * shared by several servers keeps their sessions apart. Lines in either
+ * format are read. Expired cookies are dropped and a cookie replaces the one
+ * with the same name, domain and path. The file is rewritten with 0600
+ * permissions under a lock, so concurrent runs don't lose each other's
+ * cookies.
+ *******/
+type cookieJar struct {
+	filename string
+	server   string // host name of the nventory server, without the port
+	format   string // CookieFormatJSON or CookieFormatRuby
+}
+
+// jarEntry is a line of the cookie file. Lines written before cookies were
+// kept per server have no Server, and are used for any server.
+type jarEntry struct {
+	Server string `json:",omitempty"`
+	http.Cookie
+}
+
+// newCookieJar returns the jar in filename for server, a URL or a host name.
+func newCookieJar(filename string, server string) *cookieJar {
+	return &cookieJar{filename: filename, server: stripPort(hostOf(server)), format: cookieFormat}
+}
+
+// Cookies returns the unexpired cookies of the jar's server. It doesn't need
+// the lock, since the file is replaced in one go when it's written.
+func (j *cookieJar) Cookies() ([]*http.Cookie, error) {
+	entries, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*http.Cookie, 0, len(entries))
+	for _, e := range entries {
+		if e.Server == "" || e.Server == j.server {
+			logger.Debug.Printf("Cookie Found at %v: %v (domain %v)", j.filename, e.Name, e.Domain)
+			cookies = append(cookies, &e.Cookie)
+		}
+	}
+	return cookies, nil
+}
+
+// Save adds cookies to the jar, replacing the ones with the same name, domain
+// and path. Cookies without a domain are for domain, those set to expire
+// (Max-Age<0 or an Expires in the past) are removed.
+func (j *cookieJar) Save(cookies []*http.Cookie, domain string) error {
+	if len(cookies) == 0 {
+		return nil
+	}
+	return j.update(func(entries []*jarEntry) []*jarEntry {
+		now := time.Now()
+		for _, c := range cookies {
+			e := &jarEntry{Server: j.server, Cookie: *c}
+			if e.Path == "" {
+				e.Path = "/"
+			}
+			if e.Domain == "" {
+				e.Domain = domain
+			}
+			e.normalize()
+			if e.MaxAge > 0 {
+				e.Expires = now.Add(time.Duration(e.MaxAge) * time.Second)
+				e.MaxAge = 0
+			}
+
+			kept := entries[:0]
+			for _, old := range entries {
+				if !old.sameCookie(e) {
+					kept = append(kept, old)
+				}
+			}
+			entries = kept
+			if !e.expired(now) {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	})
+}
+
+// Clear removes the cookies of the jar's server, and the cookie file once it
+// has none left.
+func (j *cookieJar) Clear() error {
+	return j.update(func(entries []*jarEntry) []*jarEntry {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.Server != "" && e.Server != j.server {
+				kept = append(kept, e)
+			}
+		}
+		return kept
+	})
+}
+
+// update replaces the entries of the cookie file with the ones returned by
+// change, under the lock.
+func (j *cookieJar) update(change func(entries []*jarEntry) []*jarEntry) error {
+	unlock, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := j.read()
+	if err != nil {
+		return err
+	}
+	entries = change(entries)
+	if len(entries) == 0 {
+		logger.Debug.Printf("Removing cookie file %v\n", j.filename)
+		if err := os.Remove(j.filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	content := ""
+	for _, e := range entries {
+		line, err := e.format(j.format)
+		if err != nil {
+			return err
+		}
+		content += line + "\n"
+	}
+	logger.Debug.Printf("Saving %v cookie(s) to %v\n", len(entries), j.filename)
+	return writeFileAtomic(j.filename, []byte(content), 0600)
+}
+
+// LoadInto puts the cookies of the jar's server in client.
+func (j *cookieJar) LoadInto(client *http.Client) {
+	cookies, err := j.Cookies()
+	if err != nil {
+		logger.Error.Printf("Unable to read cookie file %v: %v\n", j.filename, err)
+		return
+	}
+	if len(cookies) > 0 {
+		logger.Debug.Printf("Loading %v cookie(s) from file (%v)", len(cookies), j.filename)
+	}
+	for _, c := range cookies {
+		u := &url.URL{Scheme: "http", Host: c.Domain, Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		client.Jar.SetCookies(u, []*http.Cookie{c})
+	}
+}
+
+// read returns the unexpired entries of the cookie file, none if it doesn't
+// exist. Lines that can't be parsed are skipped.
+func (j *cookieJar) read() ([]*jarEntry, error) {
+	entries := make([]*jarEntry, 0)
+	f, err := os.Open(j.filename)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	reader := bufio.NewReader(f)
+	for line, err := readLine(reader); line != "" || err == nil; line, err = readLine(reader) {
+		e, err := parseCookieLine(line)
+		if err != nil {
+			logger.Debug.Printf("Skipping unreadable line of %v: %v\n", j.filename, err)
+			continue
+		}
+		e.normalize()
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// lock takes the lock of the cookie file and returns the func releasing it.
+// The lock is on a separate file, since the cookie file itself is replaced
+// when it's written.
+func (j *cookieJar) lock() (func(), error) {
+	f, err := os.OpenFile(j.filename+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// parseCookieLine parses a line of the cookie file, in either format.
+func parseCookieLine(line string) (*jarEntry, error) {
+	m := rubyCookieLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		e := &jarEntry{}
+		return e, json.Unmarshal([]byte(line), e)
+	}
+
+	parts := strings.Split(m[1], "; ")
+	pair := strings.SplitN(parts[0], "=", 2)
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("no cookie value in %q", parts[0])
+	}
+	e := &jarEntry{Cookie: http.Cookie{Name: pair[0], Value: pair[1]}}
+	seen := make(map[string]bool, 0)
+	for _, attr := range parts[1:] {
+		pair := strings.SplitN(attr, "=", 2)
+		name := strings.ToLower(pair[0])
+		value := ""
+		if len(pair) == 2 {
+			// the perl client quotes some values, like path
+			value = pair[1]
+			if len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+				value = value[1 : len(value)-1]
+			}
+		}
+		// only the first of an attribute counts, rfc2965 3.2.2
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "domain":
+			e.Domain = value
+		case "path":
+			e.Path = value
+		case "expires":
+			for _, layout := range rubyCookieTimeFormats {
+				if t, err := time.Parse(layout, value); err == nil {
+					e.Expires = t
+					break
+				}
+			}
+		case "secure":
+			e.Secure = true
+		case "httponly":
+			e.HttpOnly = true
+		}
+	}
+	return e, nil
+}
+
+// format returns e as a line of a cookie file in the given format.
+func (e *jarEntry) format(format string) (string, error) {
+	if format != CookieFormatRuby {
+		b, err := json.Marshal(e)
+		return string(b), err
+	}
+	line := fmt.Sprintf("Set-Cookie: %v=%v; path=%v; domain=%v", e.Name, e.Value, e.Path, e.Domain)
+	if !e.Expires.IsZero() {
+		line += "; expires=" + e.Expires.UTC().Format(rubyCookieTimeFormats[0])
+	}
+	if e.Secure {
+		line += "; secure"
+	}
+	if e.HttpOnly {
+		line += "; HttpOnly"
+	}
+	return line, nil
+}
+
+// sameCookie tells if o replaces e. Entries without a server are replaced by
+// the cookies of any server.
+func (e *jarEntry) sameCookie(o *jarEntry) bool {
+	return (e.Server == "" || e.Server == o.Server) && e.Name == o.Name && e.Domain == o.Domain && e.Path == o.Path
+}
+
+// normalize makes the domain of e comparable: lower case, without a leading
+// dot or a port, which older versions saved.
+func (e *jarEntry) normalize() {
+	e.Domain = stripPort(strings.TrimPrefix(strings.ToLower(e.Domain), "."))
+}
+
+func (e *jarEntry) expired(now time.Time) bool {
+	return e.MaxAge < 0 || (!e.Expires.IsZero() && !e.Expires.After(now))
+}
+
+// hostOf returns the host of u, a URL or a host name.
+func hostOf(u string) string {
+	if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return u
+}
+
+// stripPort returns host without its port, if it has one.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
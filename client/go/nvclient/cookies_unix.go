@@ -12,18 +12,43 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !windows
+
 package nvclient
 
 import (
 	"os"
 	"path"
+	"syscall"
 )
 
+// rubyAutoregCookieFile is where the ruby client keeps the autoreg session,
+// whatever HOME is.
+const rubyAutoregCookieFile = "/root/.nventory_cookie_autoreg"
+
 func getCookieFilename(login string) string {
-	home := os.Getenv("HOME")
-	filename := ".opsdb_cookie"
-	if login == autoreg {
+	filename := path.Join(os.Getenv("HOME"), ".opsdb_cookie")
+	if cookieFormat == CookieFormatRuby {
+		filename = path.Join(os.Getenv("HOME"), ".nventory_cookie")
+	}
+	if login == autoreg && cookieFormat == CookieFormatRuby {
+		filename = rubyAutoregCookieFile
+	} else if login == autoreg {
 		filename += "_" + login
+	} else if cookieFile != "" {
+		filename = cookieFile
+	}
+	if cookieProfile != "" {
+		filename += "_" + cookieProfile
 	}
-	return path.Join(home, filename)
+	return filename
+}
+
+// lockFile takes an exclusive flock on f, waiting for it.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 }
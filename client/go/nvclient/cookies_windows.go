@@ -14,9 +14,31 @@
 
 package nvclient
 
-import "path"
+import (
+	"os"
+	"path"
+)
 
-func getCookieFilename() string {
-	home := "C:\\yp"
-	return path.Join(home, ".opsdb_cookie")
+func getCookieFilename(login string) string {
+	filename := path.Join("C:\\yp", ".opsdb_cookie")
+	if cookieFormat == CookieFormatRuby {
+		filename = path.Join("C:\\yp", ".nventory_cookie")
+	}
+	if login != autoreg && cookieFile != "" {
+		filename = cookieFile
+	}
+	if cookieProfile != "" {
+		filename += "_" + cookieProfile
+	}
+	return filename
+}
+
+// lockFile doesn't lock on windows. The cookie file is still replaced in one
+// go when it's written, so it's never left half written.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
 }
@@ -0,0 +1,179 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// passwordEnv is the environment variable holding the password to log in
+// with.
+const passwordEnv = "NV_PASSWORD"
+
+/*******
+ * CredentialOptions are where the password to log in with comes from, so
+ * scripts can log in without a terminal. The sources are tried in order:
+ * PasswordFile, the NV_PASSWORD environment variable, the first line of
+ * stdin with PasswordStdin, the netrc file, and last the password prompt.
+ * The password is never logged.
+ *******/
+type CredentialOptions struct {
+	PasswordFile  string // file with the password on its first line
+	PasswordStdin bool
+	NetrcFile     string // netrc style file, ~/.netrc if empty
+}
+
+// credentials looks up passwords from CredentialOptions.
+type credentials struct {
+	CredentialOptions
+	input *bufio.Reader // stdin, for PasswordStdin and the prompt
+
+	stdinRead     bool
+	stdinPassword string
+}
+
+// password returns the password of username, for logging in to the first of
+// hosts. The other hosts are looked up in the netrc file if the first one
+// isn't there.
+func (c *credentials) password(username string, hosts ...string) (string, error) {
+	if c.PasswordFile != "" {
+		b, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", wrapError(ErrUsage, err, "Unable to read password file %v: %v\n", c.PasswordFile, err)
+		}
+		logger.Debug.Printf("Using the password of %v from %v\n", username, c.PasswordFile)
+		return firstLine(string(b)), nil
+	}
+
+	if p := os.Getenv(passwordEnv); p != "" {
+		logger.Debug.Printf("Using the password of %v from %v\n", username, passwordEnv)
+		return p, nil
+	}
+
+	if c.PasswordStdin {
+		// stdin can only be read once, the password is kept for the next
+		// logins
+		if !c.stdinRead {
+			line, err := readLine(c.reader())
+			if err != nil && line == "" {
+				return "", wrapError(ErrUsage, err, "Unable to read the password from stdin: %v\n", err)
+			}
+			c.stdinRead = true
+			c.stdinPassword = strings.TrimSuffix(line, "\r")
+		}
+		logger.Debug.Printf("Using the password of %v from stdin\n", username)
+		return c.stdinPassword, nil
+	}
+
+	netrc := c.netrcFile()
+	if p, ok := netrcPassword(netrc, username, hosts...); ok {
+		logger.Debug.Printf("Using the password of %v from %v\n", username, netrc)
+		return p, nil
+	}
+
+	_, p, err := PromptUserLogin(username, c.reader())
+	if err != nil {
+		return "", wrapError(ErrAuth, err, "Unable to read the password of %v: %v\n", username, err)
+	}
+	return p, nil
+}
+
+func (c *credentials) reader() *bufio.Reader {
+	if c.input == nil {
+		c.input = bufio.NewReader(os.Stdin)
+	}
+	return c.input
+}
+
+func (c *credentials) netrcFile() string {
+	if c.NetrcFile != "" {
+		return c.NetrcFile
+	}
+	return filepath.Join(os.Getenv("HOME"), ".netrc")
+}
+
+// netrcPassword returns the password of login in a netrc file, from the
+// machine entry of the first of hosts found, or else the default entry. An
+// entry without a login is for any login.
+func netrcPassword(filename string, login string, hosts ...string) (string, bool) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Debug.Printf("Unable to read %v: %v\n", filename, err)
+		}
+		return "", false
+	}
+
+	type entry struct{ machine, login, password string }
+	entries := make([]*entry, 0)
+	var current *entry
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		tokens := strings.Fields(lines[i])
+		for j := 0; j < len(tokens); j++ {
+			value := ""
+			if j+1 < len(tokens) {
+				value = tokens[j+1]
+			}
+			switch tokens[j] {
+			case "machine":
+				current = &entry{machine: stripPort(value)}
+				entries = append(entries, current)
+				j++
+			case "default":
+				current = &entry{}
+				entries = append(entries, current)
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				j++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				j++
+			case "account":
+				j++
+			case "macdef":
+				// a macro runs up to the next empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(tokens)
+			}
+		}
+	}
+
+	for _, host := range append(append([]string{}, hosts...), "") {
+		for _, e := range entries {
+			if e.machine == stripPort(host) && (e.login == "" || e.login == login) && e.password != "" {
+				return e.password, true
+			}
+		}
+	}
+	return "", false
+}
+
+// firstLine returns the first line of s, without its line ending.
+func firstLine(s string) string {
+	return strings.TrimSuffix(strings.SplitN(s, "\n", 2)[0], "\r")
+}
@@ -14,6 +14,11 @@
 
 package nvclient
 
+import (
+	"context"
+	"time"
+)
+
 type Driver interface {
 	//	Returns results search command.
 
@@ -22,20 +27,129 @@ type Driver interface {
 	//	includes:	extra fields to include in search to opsdb
 	//	fields:	fields to display to user
 	Search(object_type string, conditions map[string][]string, includes []string, fields []string) (Result, error)
+	SearchContext(ctx context.Context, object_type string, conditions map[string][]string, includes []string, fields []string) (Result, error)
 	// GetAllFields:	Returns all fields
 	//	command:	flags like --get name=opsdb,id=1234 (map key is "get", value is slice of values comma delimited
 	//	includes:	extra fields to include in search to opsdb
 	//	flags:		fields to display to user
 	GetAllFields(object_type string, command map[string][]string, includes []string, flags []string) (Result, error)
+	GetAllFieldsContext(ctx context.Context, object_type string, command map[string][]string, includes []string, flags []string) (Result, error)
 
 	// Set:
 	//	conditions:	flags like --get name=opsdb,id=1234 (map key is "get", value is slice of values comma delimited
 	//	includes:	extra fields to include in search to opsdb
 	//	set:		fields to set and its value
 	Set(object_type string, conditions map[string][]string, includes []string, set map[string]string, noPrompt bool) (string, error)
+	SetContext(ctx context.Context, object_type string, conditions map[string][]string, includes []string, set map[string]string, noPrompt bool) (string, error)
+
+	// Delete:
+	//	conditions:	flags like --get name=opsdb,id=1234 (map key is "get", value is slice of values comma delimited
+	//	includes:	extra fields to include in search to opsdb
+	Delete(object_type string, conditions map[string][]string, includes []string, noPrompt bool) (string, error)
+
+	// AddNodesToNodeGroups / RemoveNodesFromNodeGroups:
+	//	conditions:	flags selecting nodes, like --get name=opsdb,id=1234
+	//	nodeGroups:	names of the node groups to add the nodes to or remove them from
+	AddNodesToNodeGroups(conditions map[string][]string, nodeGroups []string, noPrompt bool) (string, error)
+	RemoveNodesFromNodeGroups(conditions map[string][]string, nodeGroups []string, noPrompt bool) (string, error)
+
+	// CreateNodeGroups:	creates an empty node group for each name
+	CreateNodeGroups(nodeGroups []string, noPrompt bool) (string, error)
+
+	// AddNodeGroupsToNodeGroups / RemoveNodeGroupsFromNodeGroups:
+	//	childGroups:	names of the node groups to add as, or remove from, child groups
+	//	parentGroups:	names of the node groups to add them to or remove them from
+	AddNodeGroupsToNodeGroups(childGroups []string, parentGroups []string, noPrompt bool) (string, error)
+	RemoveNodeGroupsFromNodeGroups(childGroups []string, parentGroups []string, noPrompt bool) (string, error)
+
+	// CreateTags:	creates a tag for each name
+	CreateTags(tags []string, noPrompt bool) (string, error)
+
+	// AddTagToNodeGroups / RemoveTagFromNodeGroups:
+	//	tag:		name of the tag, created first by AddTagToNodeGroups if it doesn't exist
+	//	nodeGroups:	names of the node groups to tag or untag
+	AddTagToNodeGroups(tag string, nodeGroups []string, noPrompt bool) (string, error)
+	RemoveTagFromNodeGroups(tag string, nodeGroups []string, noPrompt bool) (string, error)
+
+	// AddGraffiti / DeleteGraffiti:
+	//	conditions:	flags like --get name=opsdb,id=1234 (map key is "get", value is slice of values comma delimited
+	//	graffiti:	name:value pair to set
+	//	name:		name of the graffiti to delete
+	AddGraffiti(object_type string, conditions map[string][]string, graffiti string, noPrompt bool) (string, error)
+	DeleteGraffiti(object_type string, conditions map[string][]string, name string, noPrompt bool) (string, error)
+
+	// AddComment:
+	//	conditions:	flags like --get name=opsdb,id=1234 (map key is "get", value is slice of values comma delimited
+	//	comment:	text of the comment, posted as the current user
+	AddComment(object_type string, conditions map[string][]string, comment string, noPrompt bool) (string, error)
+
+	// GetNodeGroupMembers:	child groups, real and virtual nodes of a node group
+	GetNodeGroupMembers(nodeGroup string) (*NodeGroupMembers, error)
+
+	// GetExpandedNodeGroups:	names of all nodes in the node groups, including those of nested child groups
+	GetExpandedNodeGroups(nodeGroups []string) ([]string, error)
 
 	GetAllSubsystemNames(objectType string) ([]string, error)
+	GetAllSubsystemNamesContext(ctx context.Context, objectType string) ([]string, error)
+
+	// GetFieldNames:	fields of the object type with their shortcuts
+	GetFieldNames(objectType string) ([]FieldName, error)
+
+	// Register:	creates or updates the node of the local machine as autoreg
+	//	data:		node fields gathered by HostInfo, like hardware_profile[model]
+	Register(data map[string]string) (string, error)
+
+	// Login:	logs in as the user set by SetUsername unless its saved session is still valid
+	// Logout:	ends the session of the user and removes its cookie file
+	// Whoami:	session of the user, without logging in
+	Login() (*Session, error)
+	Logout() error
+	Whoami() (*Session, error)
 
 	SetServer(s string)
 	GetServer() string
+
+	// SetDryRun:	when true, Set and Delete only report the requests they would send.
+	SetDryRun(dryRun bool)
+
+	// The *Context methods cancel their requests when ctx is done.
+	// SetBaseContext:	context of the requests of the other methods, e.g. canceled on SIGINT
+	// SetTimeout:		limit of each request to the server, 0 for none
+	SetBaseContext(ctx context.Context)
+	SetTimeout(timeout time.Duration)
+
+	// SetTLSOptions:	CAs to verify the server with and client certificate, see TLSOptions
+	SetTLSOptions(opts TLSOptions) error
+
+	// SetAuthMethod:	how users log in to the server, one of AuthMethods. AuthSSO by default,
+	//		where autoreg logs in with the local form of the server.
+	SetAuthMethod(method string) error
+
+	// SetCredentialOptions:	where the password to log in with comes from, before prompting for it
+	SetCredentialOptions(opts CredentialOptions)
+
+	// SetProxy:	proxy to reach the servers through, including the SSO server.
+	//		Without one HTTP_PROXY and HTTPS_PROXY are used, hosts in NO_PROXY are reached directly.
+	SetProxy(proxy string) error
+
+	// SetSSOServer:	SSO server the nventory server redirects to for logging in,
+	//		by default any https://sso* host
+	SetSSOServer(ssoServer string)
+
+	// SetSSOLogoutURL:	where Logout ends the session on the SSO server. Without it only
+	//		the cookies of the session are removed.
+	SetSSOLogoutURL(logoutURL string)
+
+	// SetCookieFile:	file keeping the session cookies of the user, autoreg has its own
+	SetCookieFile(filename string)
+
+	// SetCookieFormat:	format of the cookie file, CookieFormatJSON (the default) or CookieFormatRuby
+	//		to share the session with the ruby and perl clients
+	SetCookieFormat(format string) error
+
+	// SetProfile:	name of the profile in use, keeping its cookies in files of its own
+	SetProfile(profile string)
+
+	// SetUsername:	user to log in as for changes, by default the current user
+	SetUsername(username string)
 }
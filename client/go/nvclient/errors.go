@@ -0,0 +1,103 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The kinds of errors returned by nvclient. Every error the client returns
+// for one of these reasons is an *Error of that Kind, so callers can tell
+// them apart with ErrorKind or errors.Is(err, ErrAuth).
+var (
+	ErrAuth          = errors.New("authentication failed")
+	ErrNotFound      = errors.New("not found")
+	ErrServer        = errors.New("server error")
+	ErrParse         = errors.New("unable to parse response")
+	ErrPartialUpdate = errors.New("some changes failed")
+	ErrUsage         = errors.New("invalid arguments")
+	ErrCanceled      = errors.New("canceled")
+)
+
+/*******
+ * Error is an error of one of the kinds above. Msg is what's shown to the
+ * user, Err is the underlying error if there is one.
+ *******/
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, a...)}
+}
+
+// wrapError returns err as an error of the given kind, unless it already
+// has one.
+func wrapError(kind error, err error, format string, a ...interface{}) error {
+	if err == nil || ErrorKind(err) != nil {
+		return err
+	}
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, a...), Err: err}
+}
+
+// ErrorKind returns the kind of err (ErrAuth, ErrNotFound, ...), or nil if
+// it isn't an *Error.
+func ErrorKind(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return nil
+}
+
+// checkResponse returns an error of the kind matching the status of a
+// response that isn't successful, or nil.
+func checkResponse(resp *http.Response, u string) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return newError(ErrAuth, "Not authorized to access %v (%v).\n", u, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return newError(ErrNotFound, "%v not found (%v).\n", u, resp.Status)
+	case resp.StatusCode >= 400:
+		return newError(ErrServer, "Request to %v failed (%v).\n", u, resp.Status)
+	}
+	return nil
+}
+
+// requestError returns the error of a request to u that couldn't be sent: of
+// kind ErrCanceled if ctx was canceled or ran out of time, ErrServer otherwise.
+func requestError(ctx context.Context, err error, u string) error {
+	if ctx.Err() != nil {
+		return wrapError(ErrCanceled, err, "Request to %v canceled: %v\n", u, ctx.Err())
+	}
+	return wrapError(ErrServer, err, "Unable to reach %v: %v\n", u, err)
+}
@@ -0,0 +1,95 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+)
+
+/*******
+ * FieldName is one field that can be searched, set or displayed for an
+ * object type, as listed by --getfieldnames.
+ *******/
+type FieldName struct {
+	Name        string   // e.g. hardware_profile[name]
+	Shortcuts   []string // aliases that can be used instead of Name, e.g. hw
+	Nested      bool     // whether the field belongs to an association
+	Association string   // the association of a nested field, e.g. hardware_profile
+}
+
+// GetFieldNames returns the fields of the object type from
+// /<objecttype>/field_names.xml, along with their shortcuts.
+func (f *NventoryClient) GetFieldNames(objectType string) ([]FieldName, error) {
+	u := fmt.Sprintf("%v/%v/field_names.xml", f.GetServer(), objectType)
+	logger.Debug.Println(fmt.Sprintf("URL: %v", u))
+
+	responseStr, err := f.getResponse(f.username, u)
+	if err != nil {
+		return nil, err
+	}
+	return getFieldNamesFromResponse(responseStr)
+}
+
+func getFieldNamesFromResponse(response string) ([]FieldName, error) {
+	names, err := search_shortcuts.SaveFieldShortcuts(response, "/field_names", "field_name", []string{}...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]FieldName, 0, len(names))
+	for _, name := range names {
+		field := FieldName{Name: name, Shortcuts: make([]string, 0)}
+		for shortcut, original := range search_shortcuts {
+			if original == name {
+				field.Shortcuts = append(field.Shortcuts, shortcut)
+			}
+		}
+		sort.Strings(field.Shortcuts)
+		if i := strings.Index(name, "["); i > 0 {
+			field.Nested = true
+			field.Association = name[:i]
+		}
+		result = append(result, field)
+	}
+	return result, nil
+}
+
+// String prints the field the way the ruby client does, with its shortcuts
+// in parentheses.
+func (field FieldName) String() string {
+	if len(field.Shortcuts) == 0 {
+		return field.Name
+	}
+	return fmt.Sprintf("%v (%v)", field.Name, strings.Join(field.Shortcuts, ", "))
+}
+
+// FieldNamesToResult converts field names into a Result, so they can be
+// printed with --output or --format like search results.
+func FieldNamesToResult(fields []FieldName) Result {
+	arr := &ResultArray{Name: "field_names", Array: make([]Result, 0, len(fields))}
+	for _, field := range fields {
+		m := &ResultMap{Name: field.Name}
+		m.Add("name", &ResultValue{Name: "name", Value: field.Name})
+		m.Add("shortcuts", &ResultValue{Name: "shortcuts", Value: strings.Join(field.Shortcuts, ",")})
+		m.Add("nested", &ResultValue{Name: "nested", Value: fmt.Sprint(field.Nested)})
+		m.Add("association", &ResultValue{Name: "association", Value: field.Association})
+		arr.Array = append(arr.Array, m)
+	}
+	return arr
+}
@@ -0,0 +1,169 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// AddGraffiti sets the graffiti (a "name:value" pair) on every object matched
+// by conditions. Objects that already have graffiti with that name get its
+// value updated instead of a second entry.
+func (f *NventoryClient) AddGraffiti(object_type string, conditions Conditions, graffiti string, login string, noPrompt bool) (string, error) {
+	pair := strings.SplitN(graffiti, ":", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return "", newError(ErrUsage, "Graffiti must be given as name:value. (%v given)\n", graffiti)
+	}
+	name, value := pair[0], pair[1]
+
+	objects, err := f.getResultMaps(object_type, conditions, []string{})
+	if err != nil {
+		return "Unable to get objects.", err
+	}
+	if len(objects) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+
+	graffitiableType := camelize(singularize(object_type))
+	plan := make([]mutation, 0, len(objects))
+	for _, obj := range objects {
+		existing, err := f.getGraffitis(graffitiableType, getResultValue(obj, "id"), name)
+		if err != nil {
+			return "Unable to get graffiti.", err
+		}
+		if len(existing) > 0 {
+			id := getResultValue(existing[0], "id")
+			values := getSetValues("graffiti", map[string]string{"value": value})
+			plan = append(plan, mutation{
+				id:     id,
+				name:   getResultName(obj) + " => " + name,
+				method: "PUT",
+				url:    f.getSetUrl("graffitis", id, values.Encode()),
+				values: values,
+			})
+			continue
+		}
+		values := url.Values{}
+		values.Set("graffiti[name]", name)
+		values.Set("graffiti[value]", value)
+		values.Set("graffiti[graffitiable_id]", getResultValue(obj, "id"))
+		values.Set("graffiti[graffitiable_type]", graffitiableType)
+		plan = append(plan, mutation{
+			name:   getResultName(obj) + " => " + name,
+			method: "POST",
+			url:    f.getCreateUrl("graffitis", values.Encode()),
+			values: values,
+		})
+	}
+
+	return f.applyMutations(plan, fmt.Sprintf("This will set graffiti %v on %v object(s), continue?  [y/N]: ", name, len(objects)), login, noPrompt, "graffiti update")
+}
+
+// DeleteGraffiti deletes the named graffiti from every object matched by
+// conditions.
+func (f *NventoryClient) DeleteGraffiti(object_type string, conditions Conditions, name string, login string, noPrompt bool) (string, error) {
+	objects, err := f.getResultMaps(object_type, conditions, []string{})
+	if err != nil {
+		return "Unable to get objects.", err
+	}
+	if len(objects) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+
+	graffitiableType := camelize(singularize(object_type))
+	notes := ""
+	plan := make([]mutation, 0, len(objects))
+	for _, obj := range objects {
+		existing, err := f.getGraffitis(graffitiableType, getResultValue(obj, "id"), name)
+		if err != nil {
+			return "Unable to get graffiti.", err
+		}
+		if len(existing) == 0 {
+			notes += fmt.Sprintf("%v has no graffiti %v, skipping.\n", getResultName(obj), name)
+			continue
+		}
+		for _, g := range existing {
+			id := getResultValue(g, "id")
+			plan = append(plan, mutation{
+				id:     id,
+				name:   getResultName(obj) + " => " + name,
+				method: "DELETE",
+				url:    f.getDeleteUrl("graffitis", id),
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will delete graffiti %v from %v object(s), continue?  [y/N]: ", name, len(objects)), login, noPrompt, "deletion")
+	return notes + msg, err
+}
+
+// getGraffitis returns the graffiti with the given name on one object.
+func (f *NventoryClient) getGraffitis(graffitiableType string, graffitiableID string, name string) ([]*ResultMap, error) {
+	return f.getResultMaps("graffitis", Conditions{"exact_": []string{"name=" + name + ",graffitiable_id=" + graffitiableID + ",graffitiable_type=" + graffitiableType}}, []string{})
+}
+
+var graffitiFieldRegex = regexp.MustCompile(`^graffiti(\[.*\])?$`)
+
+// graffitiIncludes replaces the graffiti fields (graffiti, graffiti[name]) the
+// user asks for with the graffitis association they come from.
+func graffitiIncludes(fields []string) []string {
+	result := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if graffitiFieldRegex.MatchString(f) {
+			f = "graffitis"
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// convertGraffiti replaces the graffitis array of each object in r with a
+// graffiti map from graffiti name to value, so it's displayed as
+// graffiti[name]: value.
+func convertGraffiti(r Result) Result {
+	for _, obj := range getResultMapsOf(r) {
+		g, ok := obj.Map["graffitis"]
+		if !ok {
+			continue
+		}
+		m := &ResultMap{Name: "graffiti"}
+		for _, graffiti := range getResultMapsOf(g) {
+			name := getResultValue(graffiti, "name")
+			m.Add(name, &ResultValue{Name: name, Value: getResultValue(graffiti, "value")})
+		}
+		delete(obj.Map, "graffitis")
+		obj.Map["graffiti"] = m
+		for i, k := range obj.order {
+			if k == "graffitis" {
+				obj.order[i] = "graffiti"
+			}
+		}
+	}
+	return r
+}
+
+// camelize turns an object type like node_group into its model name, NodeGroup.
+func camelize(s string) string {
+	result := ""
+	for _, part := range strings.Split(s, "_") {
+		if part != "" {
+			result += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return result
+}
@@ -16,12 +16,14 @@ package nvclient
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"flag"
@@ -30,9 +32,18 @@ import (
 )
 
 func PromptUserConfirmation(message string, f *bufio.Reader) bool {
+	return promptUserConfirmation(context.Background(), message, f)
+}
+
+// promptUserConfirmation is PromptUserConfirmation answered with no as soon as
+// ctx is done, so the first Ctrl-C ends the prompt.
+func promptUserConfirmation(ctx context.Context, message string, f *bufio.Reader) bool {
 	fmt.Print(message)
-	response, err := readLine(f)
+	response, err := readLineContext(ctx, f)
 	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println()
+		}
 		return false
 	}
 	r := []rune(response)
@@ -43,7 +54,30 @@ func PromptUserConfirmation(message string, f *bufio.Reader) bool {
 			return false
 		}
 	}
-	return PromptUserConfirmation(message, f)
+	return promptUserConfirmation(ctx, message, f)
+}
+
+// readLineContext is readLine returning ctx's error as soon as ctx is done.
+// The line being read is then lost.
+func readLineContext(ctx context.Context, f *bufio.Reader) (string, error) {
+	if ctx.Done() == nil {
+		return readLine(f)
+	}
+	type result struct {
+		line string
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		line, err := readLine(f)
+		read <- result{line, err}
+	}()
+	select {
+	case r := <-read:
+		return r.line, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
 
 func readLine(f *bufio.Reader) (string, error) {
@@ -62,7 +96,7 @@ func AssignIfStringSliceFlagNotExists(flags *SearchFlags, index int) error {
 			flags.Name = append(flags.Name, flag.Arg(index))
 			return nil
 		}
-		return errors.New(fmt.Sprintf("Argument not found. Please specify a flag or argument number %v\n", index))
+		return newError(ErrUsage, "Argument not found. Please specify a flag or argument number %v\n", index)
 
 	}
 	return nil
@@ -87,16 +121,27 @@ func PromptUserLogin(user string, f *bufio.Reader) (u, p string, err error) {
 	return user, string(passwdarr), err
 }
 
-func serializeJSON(a interface{}) (string, error) {
-	b, err := json.Marshal(a)
+// writeFileAtomic writes a copy of data next to filename then renames it, so
+// the file is never left half written.
+func writeFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
 	if err != nil {
-		return "", err
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
 	}
-	return string(b), nil
-}
-
-func deserializeJSONCookie(s string, m *http.Cookie) error {
-	err := json.Unmarshal([]byte(s), m)
 	return err
 }
 
@@ -113,6 +158,19 @@ type ExprResult struct {
 	Value string
 }
 
+// describeResponse returns the status of resp and where it redirects to, for
+// the debug logs. The headers and body are left out: they hold the session
+// cookies and, for accounts.xml, the accounts.
+func describeResponse(resp *http.Response) string {
+	if resp == nil {
+		return "no response"
+	}
+	if location := getHeaderLocation(resp); location != "" {
+		return fmt.Sprintf("%v, redirected to %v", resp.Status, location)
+	}
+	return resp.Status
+}
+
 func isRedirectResponse(resp *http.Response) bool {
 	if resp == nil {
 		return false
@@ -151,18 +209,27 @@ func SearchByCommand(f Driver, sc SearchableCommand) (Result, error) {
 	fs := sc.GetFieldsArray()
 
 	i, _ := f.GetAllSubsystemNames(sc.GetObjectType())
-	return f.Search(sc.GetObjectType(), flagMap, Intersection(i, fs), fs)
+	return f.Search(sc.GetObjectType(), flagMap, Intersection(i, graffitiIncludes(fs)), fs)
 }
 
 func SetByCommand(f Driver, sc *SetCommands) (string, error) {
 	flagMap := sc.GetFlagMap()
 
-	fs := sc.GetSetFromFlags()
+	fs, err := sc.GetSetFromFlags()
+	if err != nil {
+		return "", err
+	}
 
 	i, _ := f.GetAllSubsystemNames(sc.GetObjectType())
 	return f.Set(sc.GetObjectType(), flagMap, i, fs, sc.GetSearchCommands().IsYes())
 }
 
+func DeleteByCommand(f Driver, sc *SetCommands) (string, error) {
+	flagMap := sc.GetFlagMap()
+
+	return f.Delete(sc.GetObjectType(), flagMap, []string{}, sc.GetSearchCommands().IsYes())
+}
+
 // Get all the fields of a node
 func GetAllFieldsByCommand(f Driver, sc *SearchCommands) (Result, error) {
 	flagMap := sc.GetFlagMap()
@@ -174,6 +241,63 @@ func GetAllFieldsByCommand(f Driver, sc *SearchCommands) (Result, error) {
 	return f.GetAllFields(sc.GetObjectType(), flagMap, includes, fs)
 }
 
+// Gather facts about the local machine and register it
+func RegisterByCommand(f Driver, sc *SearchCommands) (string, error) {
+	h := NewHostInfo(sc.IsNoSwitchport(), sc.IsNoStorage())
+	data, err := h.Gather()
+	if err != nil {
+		return "", err
+	}
+	return f.Register(data)
+}
+
+// Get the field names of the --objecttype
+func GetFieldNamesByCommand(f Driver, sc *SearchCommands) ([]FieldName, error) {
+	return f.GetFieldNames(sc.GetObjectType())
+}
+
+// Get the distinct values of the --getallvalues fields of the selected objects
+func GetAllValuesByCommand(f Driver, sc *SearchCommands) ([]FieldValues, error) {
+	flagMap := sc.GetFlagMap()
+
+	fs := make([]string, 0)
+	for _, field := range sc.GetAllValues() {
+		fs = append(fs, strings.Split(field, ",")...)
+	}
+
+	i, _ := f.GetAllSubsystemNames(sc.GetObjectType())
+	includes := make([]string, 0, len(fs))
+	for _, field := range fs {
+		includes = append(includes, search_shortcuts.Replace(field))
+	}
+	res, err := f.Search(sc.GetObjectType(), flagMap, Intersection(i, graffitiIncludes(includes)), fs)
+	if err != nil {
+		return nil, err
+	}
+	return GetAllValues(res, fs, sc.GetSortValues())
+}
+
+// List the members of the --nodegroup node group
+func NodeGroupByCommand(f Driver, sc *SearchCommands) (string, error) {
+	members, err := f.GetNodeGroupMembers(sc.GetNodeGroup())
+	if err != nil {
+		return "", err
+	}
+	return members.String(), nil
+}
+
+// List all the nodes of the --nodegroupexpanded node groups, one per line
+func NodeGroupExpandedByCommand(f Driver, sc *SearchCommands) (string, error) {
+	nodes, err := f.GetExpandedNodeGroups(sc.GetNodeGroupNodes())
+	if err != nil {
+		return "", err
+	}
+	if len(nodes) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+	return strings.Join(nodes, "\n") + "\n", nil
+}
+
 type SearchableCommand interface {
 	GetSearchFlags() *SearchFlags
 	GetFlagMap() map[string][]string
@@ -0,0 +1,245 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// CommandRunner runs a command and returns its standard output.
+type CommandRunner func(name string, args ...string) (string, error)
+
+/*******
+ * HostInfo gathers the facts --register reports about the local machine
+ * through its Collectors. Files like /proc/cpuinfo are read relative to Root
+ * and commands like dmidecode are run through Run, so a recorded snapshot of
+ * /proc and /sys can be replayed in tests, see FixtureRunner.
+ *******/
+type HostInfo struct {
+	Root       string
+	Run        CommandRunner
+	Collectors []Collector
+
+	dmi DmiData
+}
+
+// NewHostInfo returns a HostInfo for the machine it runs on, with the default
+// collectors followed by the ones added with AddCollector.
+func NewHostInfo(noSwitchport bool, noStorage bool) *HostInfo {
+	return &HostInfo{
+		Root:       "/",
+		Run:        runCommand,
+		Collectors: append(DefaultCollectors(noSwitchport, noStorage), siteCollectors...),
+	}
+}
+
+func runCommand(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	return string(out), err
+}
+
+// FixtureRunner replays command output recorded in dir, in a file named after
+// the command line with spaces replaced by underscores, e.g.
+// lldpctl_-f_keyvalue for `lldpctl -f keyvalue`.
+func FixtureRunner(dir string) CommandRunner {
+	return func(name string, args ...string) (string, error) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, strings.Join(append([]string{name}, args...), "_")))
+		return string(b), err
+	}
+}
+
+// seaMicroUUID is shared by every SeaMicro box, so it can't identify one.
+const seaMicroUUID = "78563412-3412-7856-90AB-CDDEEFAABBCC"
+
+// Gather returns the node fields to register, keyed like the ruby client's
+// set_objects data, e.g. hardware_profile[model] or
+// network_interfaces[eth0][hardware_address]. A collector that fails is
+// reported and skipped, so the rest of the facts still get registered.
+func (h *HostInfo) Gather() (map[string]string, error) {
+	data := make(map[string]string, 0)
+
+	name := h.getFqdn()
+	if name == "" {
+		return nil, errors.New("Unable to determine the hostname of this machine.\n")
+	}
+	data["name"] = name
+
+	for _, c := range h.Collectors {
+		logger.Debug.Printf("Collecting %v facts\n", c.Name())
+		if err := c.Collect(h, data); err != nil {
+			logger.Error.Printf("Unable to collect %v facts: %v\n", c.Name(), err)
+		}
+	}
+
+	if uniqueid := h.getUniqueID(data); uniqueid != "" {
+		data["uniqueid"] = uniqueid
+	}
+	return data, nil
+}
+
+// Path returns the location of an absolute path like /proc/cpuinfo under Root.
+func (h *HostInfo) Path(p string) string {
+	return filepath.Join(h.Root, p)
+}
+
+// ReadFile returns the content of an absolute path under Root, without
+// surrounding whitespace.
+func (h *HostInfo) ReadFile(p string) (string, error) {
+	b, err := ioutil.ReadFile(h.Path(p))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readFile is ReadFile for optional files, returning "" if p can't be read.
+func (h *HostInfo) readFile(p string) string {
+	content, err := h.ReadFile(p)
+	if err != nil {
+		logger.Debug.Printf("Unable to read %v: %v\n", p, err)
+	}
+	return content
+}
+
+func (h *HostInfo) run(name string, args ...string) string {
+	out, err := h.Run(name, args...)
+	if err != nil {
+		logger.Debug.Printf("Unable to run %v %v: %v\n", name, strings.Join(args, " "), err)
+		return ""
+	}
+	return out
+}
+
+// getFqdn returns the hostname, qualified with the domain from
+// /etc/resolv.conf if it isn't already.
+func (h *HostInfo) getFqdn() string {
+	name := h.readFile("/proc/sys/kernel/hostname")
+	if name == "" || strings.Contains(name, ".") {
+		return name
+	}
+	domain := h.readFile("/proc/sys/kernel/domainname")
+	if domain == "(none)" {
+		domain = ""
+	}
+	if domain == "" {
+		for _, line := range strings.Split(h.readFile("/etc/resolv.conf"), "\n") {
+			fs := strings.Fields(line)
+			if len(fs) > 1 && (fs[0] == "domain" || fs[0] == "search") {
+				domain = fs[1]
+				break
+			}
+		}
+	}
+	if domain == "" {
+		return name
+	}
+	return name + "." + domain
+}
+
+// DmiData holds the sections of dmidecode output by name, e.g.
+// "Memory Device", each a map of its fields.
+type DmiData map[string][]map[string]string
+
+// Get returns the first value of key in the sections named sectionName.
+func (d DmiData) Get(sectionName string, key string) string {
+	for _, section := range d[sectionName] {
+		if v := section[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// DmiData runs dmidecode once and returns its parsed output.
+func (h *HostInfo) DmiData() DmiData {
+	if h.dmi != nil {
+		return h.dmi
+	}
+	h.dmi = make(DmiData, 0)
+
+	var section map[string]string
+	sectionName := ""
+	lookForName := false
+	for _, line := range strings.Split(h.run("dmidecode"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Handle"):
+			section = make(map[string]string, 0)
+			sectionName = ""
+			lookForName = true
+		case lookForName:
+			if trimmed == "" || strings.HasPrefix(trimmed, "DMI type") {
+				continue
+			}
+			sectionName = trimmed
+			h.dmi[sectionName] = append(h.dmi[sectionName], section)
+			lookForName = false
+		case sectionName != "":
+			pair := strings.SplitN(trimmed, ":", 2)
+			if len(pair) == 2 && strings.TrimSpace(pair[1]) != "" {
+				section[pair[0]] = strings.TrimSpace(pair[1])
+			}
+		}
+	}
+	return h.dmi
+}
+
+// getUniqueID returns the system UUID from dmidecode, or the MAC address of
+// the first NIC on xen guests and on hardware known to share the same UUID.
+func (h *HostInfo) getUniqueID(data map[string]string) string {
+	macs := make([]string, 0)
+	for k, v := range data {
+		if strings.HasPrefix(k, "network_interfaces[") && strings.HasSuffix(k, "][hardware_address]") && v != "00:00:00:00:00:00" {
+			macs = append(macs, k+"="+v)
+		}
+	}
+	sort.Strings(macs)
+	mac := ""
+	if len(macs) > 0 {
+		mac = macs[0][strings.Index(macs[0], "=")+1:]
+	}
+
+	uuid := h.DmiData().Get("System Information", "UUID")
+	if data["virtualarch"] == "xen" && data["virtualmode"] == "guest" {
+		uuid = ""
+	} else if strings.Contains(data["hardware_profile[manufacturer]"], "Dell") && data["hardware_profile[model]"] == "C6100" {
+		uuid = ""
+	}
+	if uuid == "" || uuid == seaMicroUUID {
+		return mac
+	}
+	return uuid
+}
+
+// parseKeyValues parses lines of key<sep>value pairs, like /etc/os-release,
+// removing quotes around the values.
+func parseKeyValues(content string, sep string) map[string]string {
+	result := make(map[string]string, 0)
+	for _, line := range strings.Split(content, "\n") {
+		pair := strings.SplitN(line, sep, 2)
+		if len(pair) != 2 {
+			continue
+		}
+		result[strings.TrimSpace(pair[0])] = strings.Trim(strings.TrimSpace(pair[1]), `"'`)
+	}
+	return result
+}
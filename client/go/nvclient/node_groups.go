@@ -0,0 +1,449 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// AddNodesToNodeGroups assigns every node matched by conditions to each of the
+// named node groups. Unlike the ruby client, it creates assignments through
+// the node_group_node_assignments controller one at a time instead of
+// resetting the whole member list, so concurrent updates aren't lost.
+func (f *NventoryClient) AddNodesToNodeGroups(conditions Conditions, nodeGroups []string, login string, noPrompt bool) (string, error) {
+	nodes, err := f.getResultMaps("nodes", conditions, []string{})
+	if err != nil {
+		return "Unable to get nodes.", err
+	}
+	if len(nodes) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+	groups, err := f.getNodeGroupsByName(nodeGroups)
+	if err != nil {
+		return "", err
+	}
+
+	notes := ""
+	plan := make([]mutation, 0)
+	for _, group := range groups {
+		groupID := getResultValue(group, "id")
+		assignments, err := f.getNodeGroupNodeAssignments(groupID)
+		if err != nil {
+			return "Unable to get node group assignments.", err
+		}
+		for _, node := range nodes {
+			nodeID := getResultValue(node, "id")
+			if _, ok := assignments[nodeID]; ok {
+				notes += fmt.Sprintf("%v is already a member of %v, skipping.\n", getResultName(node), getResultName(group))
+				continue
+			}
+			values := url.Values{}
+			values.Set("node_group_node_assignment[node_id]", nodeID)
+			values.Set("node_group_node_assignment[node_group_id]", groupID)
+			plan = append(plan, mutation{
+				name:   getResultName(node) + " => " + getResultName(group),
+				method: "POST",
+				url:    f.getCreateUrl("node_group_node_assignments", values.Encode()),
+				values: values,
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will add %v node(s) to %v node group(s), continue?  [y/N]: ", len(nodes), len(groups)), login, noPrompt, "assignment")
+	return notes + msg, err
+}
+
+// RemoveNodesFromNodeGroups deletes the assignments of every node matched by
+// conditions from each of the named node groups. Virtual assignments, which
+// come from membership in a child group, are left alone.
+func (f *NventoryClient) RemoveNodesFromNodeGroups(conditions Conditions, nodeGroups []string, login string, noPrompt bool) (string, error) {
+	nodes, err := f.getResultMaps("nodes", conditions, []string{})
+	if err != nil {
+		return "Unable to get nodes.", err
+	}
+	if len(nodes) == 0 {
+		return fmt.Sprintln("No matching objects"), nil
+	}
+	groups, err := f.getNodeGroupsByName(nodeGroups)
+	if err != nil {
+		return "", err
+	}
+
+	notes := ""
+	plan := make([]mutation, 0)
+	for _, group := range groups {
+		assignments, err := f.getNodeGroupNodeAssignments(getResultValue(group, "id"))
+		if err != nil {
+			return "Unable to get node group assignments.", err
+		}
+		for _, node := range nodes {
+			assignment, ok := assignments[getResultValue(node, "id")]
+			if !ok {
+				notes += fmt.Sprintf("%v is not a member of %v, skipping.\n", getResultName(node), getResultName(group))
+				continue
+			}
+			if getResultValue(assignment, "virtual_assignment") == "true" {
+				notes += fmt.Sprintf("%v is a member of %v through a child group, skipping.\n", getResultName(node), getResultName(group))
+				continue
+			}
+			id := getResultValue(assignment, "id")
+			plan = append(plan, mutation{
+				id:     id,
+				name:   getResultName(node) + " => " + getResultName(group),
+				method: "DELETE",
+				url:    f.getDeleteUrl("node_group_node_assignments", id),
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will remove %v node(s) from %v node group(s), continue?  [y/N]: ", len(nodes), len(groups)), login, noPrompt, "removal")
+	return notes + msg, err
+}
+
+// CreateNodeGroups creates an empty node group for each name.
+func (f *NventoryClient) CreateNodeGroups(nodeGroups []string, login string, noPrompt bool) (string, error) {
+	plan := make([]mutation, 0, len(nodeGroups))
+	for _, name := range nodeGroups {
+		values := getSetValues(singularize("node_groups"), map[string]string{"name": name})
+		plan = append(plan, mutation{name: name, method: "POST", url: f.getCreateUrl("node_groups", values.Encode()), values: values})
+	}
+
+	return f.applyMutations(plan, fmt.Sprintf("This will create new node group(s) (%v), continue?  [y/N]: ", strings.Join(nodeGroups, ",")), login, noPrompt, "creation")
+}
+
+// AddNodeGroupsToNodeGroups makes each of the child groups a member of each
+// of the parent groups, through the node_group_node_group_assignments
+// controller. An assignment that would create a cycle in the group hierarchy
+// fails the whole request before anything is changed.
+func (f *NventoryClient) AddNodeGroupsToNodeGroups(childGroups []string, parentGroups []string, login string, noPrompt bool) (string, error) {
+	children, err := f.getNodeGroupsByName(childGroups)
+	if err != nil {
+		return "", err
+	}
+	parents, err := f.getNodeGroupsByName(parentGroups)
+	if err != nil {
+		return "", err
+	}
+
+	// child group names of each group seen so far, including planned edges
+	graph := make(map[string][]string, 0)
+	notes := ""
+	plan := make([]mutation, 0)
+	for _, parent := range parents {
+		parentName := getResultName(parent)
+		assignments, err := f.getNodeGroupNodeGroupAssignments(getResultValue(parent, "id"))
+		if err != nil {
+			return "Unable to get node group assignments.", err
+		}
+		for _, child := range children {
+			childName := getResultName(child)
+			if _, ok := assignments[getResultValue(child, "id")]; ok {
+				notes += fmt.Sprintf("%v is already a child group of %v, skipping.\n", childName, parentName)
+				continue
+			}
+			// the new edge closes a cycle if the parent is reachable from the child
+			path, err := f.findNodeGroupPath(childName, parentName, graph, make(map[string]bool, 0))
+			if err != nil {
+				return "", err
+			}
+			if path != nil {
+				return "", newError(ErrUsage, "Adding %v to %v would create a node group cycle: %v -> %v\n", childName, parentName, parentName, strings.Join(path, " -> "))
+			}
+			if _, err := f.getChildGroupNames(parentName, graph); err != nil {
+				return "", err
+			}
+			graph[parentName] = append(graph[parentName], childName)
+
+			values := url.Values{}
+			values.Set("node_group_node_group_assignment[parent_id]", getResultValue(parent, "id"))
+			values.Set("node_group_node_group_assignment[child_id]", getResultValue(child, "id"))
+			plan = append(plan, mutation{
+				name:   childName + " => " + parentName,
+				method: "POST",
+				url:    f.getCreateUrl("node_group_node_group_assignments", values.Encode()),
+				values: values,
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will add %v node group(s) to %v node group(s), continue?  [y/N]: ", len(children), len(parents)), login, noPrompt, "assignment")
+	return notes + msg, err
+}
+
+// RemoveNodeGroupsFromNodeGroups deletes the assignments of each of the child
+// groups to each of the parent groups.
+func (f *NventoryClient) RemoveNodeGroupsFromNodeGroups(childGroups []string, parentGroups []string, login string, noPrompt bool) (string, error) {
+	children, err := f.getNodeGroupsByName(childGroups)
+	if err != nil {
+		return "", err
+	}
+	parents, err := f.getNodeGroupsByName(parentGroups)
+	if err != nil {
+		return "", err
+	}
+
+	notes := ""
+	plan := make([]mutation, 0)
+	for _, parent := range parents {
+		assignments, err := f.getNodeGroupNodeGroupAssignments(getResultValue(parent, "id"))
+		if err != nil {
+			return "Unable to get node group assignments.", err
+		}
+		for _, child := range children {
+			assignment, ok := assignments[getResultValue(child, "id")]
+			if !ok {
+				notes += fmt.Sprintf("%v is not a child group of %v, skipping.\n", getResultName(child), getResultName(parent))
+				continue
+			}
+			id := getResultValue(assignment, "id")
+			plan = append(plan, mutation{
+				id:     id,
+				name:   getResultName(child) + " => " + getResultName(parent),
+				method: "DELETE",
+				url:    f.getDeleteUrl("node_group_node_group_assignments", id),
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will remove %v node group(s) from %v node group(s), continue?  [y/N]: ", len(children), len(parents)), login, noPrompt, "removal")
+	return notes + msg, err
+}
+
+// getNodeGroupsByName looks up each node group by exact name, failing if any
+// of them doesn't exist.
+func (f *NventoryClient) getNodeGroupsByName(names []string) ([]*ResultMap, error) {
+	result := make([]*ResultMap, 0, len(names))
+	for _, name := range names {
+		group, err := f.getNodeGroupByName(name, []string{})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, group)
+	}
+	return result, nil
+}
+
+// getNodeGroupByName looks up a single node group by exact name along with the
+// given associations (e.g. nodes, child_groups).
+func (f *NventoryClient) getNodeGroupByName(name string, includes []string) (*ResultMap, error) {
+	res, err := f.getObjects("node_groups", Conditions{"exact_": []string{"name=" + name}}, includes)
+	if err != nil {
+		return nil, err
+	}
+	groups := getResultMapsOf(res)
+	if len(groups) != 1 {
+		return nil, newError(ErrNotFound, "Node group '%v' not found.\n", name)
+	}
+	return groups[0], nil
+}
+
+// getNodeGroupNodeAssignments returns the group's assignments keyed by node id.
+func (f *NventoryClient) getNodeGroupNodeAssignments(groupID string) (map[string]*ResultMap, error) {
+	assignments, err := f.getResultMaps("node_group_node_assignments", Conditions{"exact_": []string{"node_group_id=" + groupID}}, []string{})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*ResultMap, len(assignments))
+	for _, a := range assignments {
+		result[getResultValue(a, "node_id")] = a
+	}
+	return result, nil
+}
+
+/*******
+ * NodeGroupMembers lists the direct members of a node group, as shown by
+ * --nodegroup. Child groups are listed by name and are not expanded.
+ *******/
+type NodeGroupMembers struct {
+	Name         string
+	ChildGroups  []string
+	RealNodes    []string
+	VirtualNodes []string
+}
+
+func (m *NodeGroupMembers) String() string {
+	s := "Child groups:\n"
+	for _, name := range m.ChildGroups {
+		s += fmt.Sprintf("  %v\n", name)
+	}
+	s += "====================\nReal Nodes:\n"
+	for _, name := range m.RealNodes {
+		s += fmt.Sprintf("  %v\n", name)
+	}
+	s += "====================\nVirtual Nodes:\n"
+	for _, name := range m.VirtualNodes {
+		s += fmt.Sprintf("  %v\n", name)
+	}
+	return s
+}
+
+// GetNodeGroupMembers returns the child groups and the real and virtual nodes
+// of the named node group. Virtual nodes are members through a child group.
+func (f *NventoryClient) GetNodeGroupMembers(nodeGroup string) (*NodeGroupMembers, error) {
+	group, err := f.getNodeGroupByName(nodeGroup, []string{"child_groups"})
+	if err != nil {
+		return nil, err
+	}
+	members := &NodeGroupMembers{
+		Name:         getResultName(group),
+		ChildGroups:  make([]string, 0),
+		RealNodes:    splitNames(getResultValue(group, "real_nodes_names")),
+		VirtualNodes: splitNames(getResultValue(group, "virtual_nodes_names")),
+	}
+	for _, child := range getResultMapsOf(group.Get("child_groups")) {
+		members.ChildGroups = append(members.ChildGroups, getResultName(child))
+	}
+	sort.Strings(members.ChildGroups)
+	return members, nil
+}
+
+// GetExpandedNodeGroups returns the sorted names of all nodes in the named
+// node groups, following child groups recursively. A group that (directly or
+// indirectly) contains itself is reported as an error instead of looping.
+func (f *NventoryClient) GetExpandedNodeGroups(nodeGroups []string) ([]string, error) {
+	nodes := make(map[string]bool, 0)
+	expanded := make(map[string]bool, 0)
+	for _, name := range nodeGroups {
+		if err := f.expandNodeGroup(name, []string{}, expanded, nodes); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]string, 0, len(nodes))
+	for name := range nodes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// expandNodeGroup adds the nodes of the named group and its child groups to
+// nodes. path holds the groups being expanded above this one, expanded the
+// groups already done.
+func (f *NventoryClient) expandNodeGroup(name string, path []string, expanded map[string]bool, nodes map[string]bool) error {
+	for _, p := range path {
+		if p == name {
+			return errors.New(fmt.Sprintf("Node group cycle detected: %v -> %v\n", strings.Join(path, " -> "), name))
+		}
+	}
+	if expanded[name] {
+		return nil
+	}
+	group, err := f.getNodeGroupByName(name, []string{"nodes", "child_groups"})
+	if err != nil {
+		return err
+	}
+	for _, node := range getResultMapsOf(group.Get("nodes")) {
+		nodes[getResultName(node)] = true
+	}
+	for _, child := range getResultMapsOf(group.Get("child_groups")) {
+		if err := f.expandNodeGroup(getResultName(child), append(path, name), expanded, nodes); err != nil {
+			return err
+		}
+	}
+	expanded[name] = true
+	return nil
+}
+
+// getNodeGroupNodeGroupAssignments returns the group's child group assignments
+// keyed by child group id.
+func (f *NventoryClient) getNodeGroupNodeGroupAssignments(parentID string) (map[string]*ResultMap, error) {
+	assignments, err := f.getResultMaps("node_group_node_group_assignments", Conditions{"exact_": []string{"parent_id=" + parentID}}, []string{})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*ResultMap, len(assignments))
+	for _, a := range assignments {
+		result[getResultValue(a, "child_id")] = a
+	}
+	return result, nil
+}
+
+// findNodeGroupPath returns the chain of child groups leading from one node
+// group to another, or nil if there is none. graph caches the child group
+// names fetched so far.
+func (f *NventoryClient) findNodeGroupPath(from string, to string, graph map[string][]string, visited map[string]bool) ([]string, error) {
+	if from == to {
+		return []string{from}, nil
+	}
+	if visited[from] {
+		return nil, nil
+	}
+	visited[from] = true
+	children, err := f.getChildGroupNames(from, graph)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		path, err := f.findNodeGroupPath(child, to, graph, visited)
+		if err != nil || path != nil {
+			if path != nil {
+				path = append([]string{from}, path...)
+			}
+			return path, err
+		}
+	}
+	return nil, nil
+}
+
+// getChildGroupNames returns the names of the group's child groups, fetching
+// them into graph the first time.
+func (f *NventoryClient) getChildGroupNames(name string, graph map[string][]string) ([]string, error) {
+	if children, ok := graph[name]; ok {
+		return children, nil
+	}
+	group, err := f.getNodeGroupByName(name, []string{"child_groups"})
+	if err != nil {
+		return nil, err
+	}
+	graph[name] = make([]string, 0)
+	for _, child := range getResultMapsOf(group.Get("child_groups")) {
+		graph[name] = append(graph[name], getResultName(child))
+	}
+	return graph[name], nil
+}
+
+// getResultMapsOf returns the objects in r, which may be a single object or
+// an array of them.
+func getResultMapsOf(r Result) []*ResultMap {
+	result := make([]*ResultMap, 0)
+	switch t := r.(type) {
+	case *ResultArray:
+		for _, item := range t.Array {
+			if m, ok := item.(*ResultMap); ok {
+				result = append(result, m)
+			}
+		}
+	case *ResultMap:
+		result = append(result, t)
+	}
+	return result
+}
+
+// splitNames splits a comma separated list of names, as returned in
+// real_nodes_names, and sorts it.
+func splitNames(s string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
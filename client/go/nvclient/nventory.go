@@ -15,13 +15,15 @@
 package nvclient
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/url"
+	"os"
 	"os/user"
 	"strings"
 	"regexp"
 	"net/http"
+	"time"
 
 
 	logger "github.com/atclate/go-logger"
@@ -33,9 +35,20 @@ const autoreg = "autoreg"
 
 var autoreg_password = "REPLACE_ME_WITH_AUTOREG_PASSWORD"
 
+// cookieFile is the cookiefile setting of nventory.conf. The cookies of a
+// profile are kept apart, in the cookie file named after it with the
+// _<cookieProfile> suffix.
+var cookieFile string
+var cookieProfile string
+
+// cookieFormat is the cookie_format setting of nventory.conf, the format of
+// the cookie files.
+var cookieFormat = CookieFormatJSON
+
 
 type NventoryDriver struct {
 	server         string
+	username       string
 	input          *bufio.Reader
 	nventoryClient *NventoryClient
 }
@@ -56,11 +69,83 @@ func (d *NventoryDriver) GetServer() string {
 	return d.server
 }
 
+func (d *NventoryDriver) SetDryRun(dryRun bool) {
+	d.nventoryClient.SetDryRun(dryRun)
+}
+
+func (d *NventoryDriver) SetBaseContext(ctx context.Context) {
+	d.nventoryClient.SetBaseContext(ctx)
+}
+
+func (d *NventoryDriver) SetTimeout(timeout time.Duration) {
+	d.nventoryClient.SetTimeout(timeout)
+}
+
+func (d *NventoryDriver) SetTLSOptions(opts TLSOptions) error {
+	return d.nventoryClient.SetTLSOptions(opts)
+}
+
+func (d *NventoryDriver) SetAuthMethod(method string) error {
+	return d.nventoryClient.SetAuthMethod(method)
+}
+
+func (d *NventoryDriver) SetCredentialOptions(opts CredentialOptions) {
+	d.nventoryClient.SetCredentialOptions(opts)
+}
+
+func (d *NventoryDriver) SetProxy(proxy string) error {
+	return d.nventoryClient.SetProxy(proxy)
+}
+
+func (d *NventoryDriver) SetSSOServer(ssoServer string) {
+	d.nventoryClient.SetSSOServer(ssoServer)
+}
+
+func (d *NventoryDriver) SetSSOLogoutURL(logoutURL string) {
+	d.nventoryClient.SetSSOLogoutURL(logoutURL)
+}
+
+func (d *NventoryDriver) SetCookieFile(filename string) {
+	cookieFile = filename
+}
+
+func (d *NventoryDriver) SetCookieFormat(format string) error {
+	switch format {
+	case "":
+		cookieFormat = CookieFormatJSON
+	case CookieFormatJSON, CookieFormatRuby:
+		cookieFormat = format
+	default:
+		return newError(ErrUsage, "Unknown cookie_format %v, must be %v or %v.\n", format, CookieFormatJSON, CookieFormatRuby)
+	}
+	return nil
+}
+
+func (d *NventoryDriver) SetProfile(profile string) {
+	cookieProfile = profile
+}
+
+func (d *NventoryDriver) SetUsername(username string) {
+	d.username = username
+}
+
+// getLogin returns the user changes are made as: the one set by SetUsername,
+// or the current user.
+func (d *NventoryDriver) getLogin() string {
+	if d.username != "" {
+		return d.username
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
 func (d *NventoryDriver) SetAutoregPassword(s string) {
 	autoreg_password = s
 }
 
-func (sc *SetCommands) GetSetFromFlags() map[string]string {
+func (sc *SetCommands) GetSetFromFlags() (map[string]string, error) {
 	fs := make(map[string]string, 0)
 	for _, ss := range sc.setValueFlags.value {
 		for _, s := range strings.Split(ss, ",") {
@@ -68,53 +153,167 @@ func (sc *SetCommands) GetSetFromFlags() map[string]string {
 			if len(splits) == 2 {
 				fs[splits[0]] = splits[1]
 			} else {
-				log.Fatalf("= required in set flags. (%v doesn't contain '='", s)
+				return nil, newError(ErrUsage, "= required in set flags. (%v doesn't contain '=')\n", s)
 			}
 		}
 	}
-	return fs
+	return fs, nil
 }
 
 func (f *NventoryDriver) Search(object_type string, conditions map[string][]string, includes []string, fields []string) (Result, error) {
+	return f.SearchContext(f.nventoryClient.context(), object_type, conditions, includes, fields)
+}
+
+func (f *NventoryDriver) SearchContext(ctx context.Context, object_type string, conditions map[string][]string, includes []string, fields []string) (Result, error) {
 	logger.Debug.Println("searching %v in nventory for %v", object_type, fields)
-	return f.nventoryClient.GetObjects(object_type, conditions, includes)
+	return f.nventoryClient.GetObjectsContext(ctx, object_type, conditions, includes)
 }
 
 func (f *NventoryDriver) Set(object_type string, conditions map[string][]string, includes []string, set map[string]string, npPrompt bool) (string, error) {
+	return f.SetContext(f.nventoryClient.context(), object_type, conditions, includes, set, npPrompt)
+}
+
+func (f *NventoryDriver) SetContext(ctx context.Context, object_type string, conditions map[string][]string, includes []string, set map[string]string, npPrompt bool) (string, error) {
 	logger.Debug.Println("setting %v in nventory to %v", object_type, set)
-	u, _ := user.Current()
-	return f.nventoryClient.SetObjects("nodes", conditions, includes, set, u.Username, npPrompt)
+	return f.nventoryClient.SetObjectsContext(ctx, "nodes", conditions, includes, set, f.getLogin(), npPrompt)
+}
+
+func (f *NventoryDriver) Delete(object_type string, conditions map[string][]string, includes []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("deleting %v in nventory\n", object_type)
+	return f.nventoryClient.DeleteObjects(object_type, conditions, includes, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) AddNodesToNodeGroups(conditions map[string][]string, nodeGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("adding nodes to node groups %v in nventory\n", nodeGroups)
+	return f.nventoryClient.AddNodesToNodeGroups(conditions, nodeGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) RemoveNodesFromNodeGroups(conditions map[string][]string, nodeGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("removing nodes from node groups %v in nventory\n", nodeGroups)
+	return f.nventoryClient.RemoveNodesFromNodeGroups(conditions, nodeGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) CreateNodeGroups(nodeGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("creating node groups %v in nventory\n", nodeGroups)
+	return f.nventoryClient.CreateNodeGroups(nodeGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) AddNodeGroupsToNodeGroups(childGroups []string, parentGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("adding node groups %v to node groups %v in nventory\n", childGroups, parentGroups)
+	return f.nventoryClient.AddNodeGroupsToNodeGroups(childGroups, parentGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) RemoveNodeGroupsFromNodeGroups(childGroups []string, parentGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("removing node groups %v from node groups %v in nventory\n", childGroups, parentGroups)
+	return f.nventoryClient.RemoveNodeGroupsFromNodeGroups(childGroups, parentGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) CreateTags(tags []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("creating tags %v in nventory\n", tags)
+	return f.nventoryClient.CreateTags(tags, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) AddTagToNodeGroups(tag string, nodeGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("adding tag %v to node groups %v in nventory\n", tag, nodeGroups)
+	return f.nventoryClient.AddTagToNodeGroups(tag, nodeGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) RemoveTagFromNodeGroups(tag string, nodeGroups []string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("removing tag %v from node groups %v in nventory\n", tag, nodeGroups)
+	return f.nventoryClient.RemoveTagFromNodeGroups(tag, nodeGroups, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) AddGraffiti(object_type string, conditions map[string][]string, graffiti string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("adding graffiti %v to %v in nventory\n", graffiti, object_type)
+	return f.nventoryClient.AddGraffiti(object_type, conditions, graffiti, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) DeleteGraffiti(object_type string, conditions map[string][]string, name string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("deleting graffiti %v from %v in nventory\n", name, object_type)
+	return f.nventoryClient.DeleteGraffiti(object_type, conditions, name, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) AddComment(object_type string, conditions map[string][]string, comment string, noPrompt bool) (string, error) {
+	logger.Debug.Printf("adding comment to %v in nventory\n", object_type)
+	return f.nventoryClient.AddComment(object_type, conditions, comment, f.getLogin(), noPrompt)
+}
+
+func (f *NventoryDriver) GetNodeGroupMembers(nodeGroup string) (*NodeGroupMembers, error) {
+	logger.Debug.Printf("getting members of node group %v in nventory\n", nodeGroup)
+	return f.nventoryClient.GetNodeGroupMembers(nodeGroup)
+}
+
+func (f *NventoryDriver) GetExpandedNodeGroups(nodeGroups []string) ([]string, error) {
+	logger.Debug.Printf("expanding node groups %v in nventory\n", nodeGroups)
+	return f.nventoryClient.GetExpandedNodeGroups(nodeGroups)
+}
+
+func (f *NventoryDriver) GetFieldNames(objectType string) ([]FieldName, error) {
+	logger.Debug.Printf("getting field names of %v in nventory\n", objectType)
+	return f.nventoryClient.GetFieldNames(objectType)
+}
+
+func (f *NventoryDriver) Register(data map[string]string) (string, error) {
+	logger.Debug.Printf("registering %v in nventory\n", data["name"])
+	return f.nventoryClient.Register(data)
+}
+
+func (f *NventoryDriver) Login() (*Session, error) {
+	logger.Debug.Printf("logging in to %v as %v\n", f.GetServer(), f.getLogin())
+	return f.nventoryClient.Login(f.getLogin())
+}
+
+func (f *NventoryDriver) Logout() error {
+	logger.Debug.Printf("logging out of %v as %v\n", f.GetServer(), f.getLogin())
+	return f.nventoryClient.Logout(f.getLogin())
+}
+
+func (f *NventoryDriver) Whoami() (*Session, error) {
+	return f.nventoryClient.Whoami(f.getLogin())
 }
 
 func (f *NventoryDriver) GetAllSubsystemNames(objectType string) ([]string, error) {
+	return f.GetAllSubsystemNamesContext(f.nventoryClient.context(), objectType)
+}
+
+func (f *NventoryDriver) GetAllSubsystemNamesContext(ctx context.Context, objectType string) ([]string, error) {
 	logger.Debug.Println("searching in nventory for all subsystemnames with search subcommand ", objectType)
-	return f.nventoryClient.GetAllSubsystemNames(objectType)
+	return f.nventoryClient.GetAllSubsystemNamesContext(ctx, objectType)
 }
 
-func (f *NventoryDriver) GetHttpClientFor(username string) *http.Client {
+func (f *NventoryDriver) GetHttpClientFor(username string) (*http.Client, error) {
 	return f.nventoryClient.GetHttpClientFor(username)
 }
 
 func (f *NventoryDriver) GetAllFields(object_type string, command map[string][]string, includes []string, flags []string) (Result, error) {
+	return f.GetAllFieldsContext(f.nventoryClient.context(), object_type, command, includes, flags)
+}
 
-	client := f.GetHttpClientFor(autoreg)
+func (f *NventoryDriver) GetAllFieldsContext(ctx context.Context, object_type string, command map[string][]string, includes []string, flags []string) (Result, error) {
+	client := f.nventoryClient.withContext(ctx)
 
-	fields, err := f.GetAllSubsystemNames(object_type)
+	if _, err := client.GetHttpClientFor(autoreg); err != nil {
+		return nil, err
+	}
+
+	fields, err := client.GetAllSubsystemNames(object_type)
 	if err != nil {
 		return nil, err
 	}
 	u := getSearchUrl(f.GetServer(), object_type, command, fields)
 
-	resp, _ := client.Get(u)
-
 	logger.Debug.Println(fmt.Sprintf("URL: %v", u))
 
-	responseStr, err := readResponseBody(resp.Body)
+	responseStr, err := client.getResponse(autoreg, u)
 	if err != nil {
-		log.Fatal("Unable to read response body.")
+		return nil, err
 	}
 
-	return GetResultsFromResponse(responseStr)
+	res, err := GetResultsFromResponse(responseStr)
+	if err != nil {
+		return nil, err
+	}
+	return convertGraffiti(res), nil
 }
 
 func Intersection(allSubsystemNames []string, fields []string) []string {
@@ -136,20 +335,23 @@ func getSearchUrl(hostname string, object_type string, searchCommand map[string]
 		values = mergeMapOfStringArrays(values, Separate(v, k))
 	}
 
+	// includes are sent as include[field]=, like the ruby client does, or
+	// include[field]=tags for the tags of an association (e.g.
+	// node_groups[tags][name]). Other fields of the association don't drop
+	// its tags.
+	var fieldsRegex = regexp.MustCompile(`([^[]+)\[.+\]`)
 	for _, f := range includes {
-		values := make([]string, 0)
-		var fieldsRegex = regexp.MustCompile(`([^[]+)\[.+\]`)
+		name, val := f, ""
 		if fieldsRegex.MatchString(f) {
 			// field[subfield]
-			fieldName := fieldsRegex.FindAllStringSubmatch(f, -1)
-			val := []string{""}
+			name = fieldsRegex.FindAllStringSubmatch(f, -1)[0][1]
 			if strings.Contains(f, "[tags]") {
-				val = append(val, "tags")
+				val = "tags"
 			}
-			values = append(values, fmt.Sprintf("includes[%v]=%v", fieldName[0][1], val))
-		} else {
-			// field
-			values = append(values, fmt.Sprintf("includes[%v]=", f))
+		}
+		key := fmt.Sprintf("include[%v]", name)
+		if values.Get(key) != "tags" {
+			values.Set(key, val)
 		}
 	}
 
@@ -15,18 +15,31 @@
 package nvclient
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"bufio"
 	"net/http"
 	"net/http/httptest"
 
 	"os"
+	"path/filepath"
 
 	logger "github.com/atclate/go-logger"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli"
 )
@@ -69,7 +82,8 @@ func TestCookies(t *testing.T) {
 	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
 	driver.SetServer(tp.URL)
 	//driver.SetServer("http://opsdb")
-	httpClient := driver.GetHttpClientFor(autoreg)
+	httpClient, err := driver.GetHttpClientFor(autoreg)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
 	assert.NotNil(t, httpClient, "http.Client hould not be nil")
 }
 
@@ -281,6 +295,288 @@ func TestSetNodesInNventory(t *testing.T) {
 	}
 }
 
+func TestDeleteNodesInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tcs := []TSC2{
+		// --name ceph5.np.ev1.example.com --delete
+		{
+			TSCI2{
+				&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"ceph5.np.ev1.example.com"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, delete: true},
+				[]string{},
+				"y\n",
+			},
+			[]string{"1 out of 1 deletion(s) succeeded."},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">53833</id><name>ceph5.np.ev1.example.com</name></node></nodes>`},
+				{key: resp_key{m: "DELETE", p: "/nodes/53833.xml"}, code: 200, response: ``},
+			},
+		},
+		// --name ceph5.np.ev1.example.com --delete, answering no
+		{
+			TSCI2{
+				&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"ceph5.np.ev1.example.com"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, delete: true},
+				[]string{},
+				"n\n",
+			},
+			[]string{"Cancelled"},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">53833</id><name>ceph5.np.ev1.example.com</name></node></nodes>`},
+			},
+		},
+		// --name ceph5.np.ev1.example.com --delete, server refuses
+		{
+			TSCI2{
+				&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"ceph5.np.ev1.example.com"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, delete: true},
+				[]string{},
+				"y\n",
+			},
+			[]string{"0 out of 1 deletion(s) succeeded."},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">53833</id><name>ceph5.np.ev1.example.com</name></node></nodes>`},
+				{key: resp_key{m: "DELETE", p: "/nodes/53833.xml"}, code: 422, response: `<errors><error>Cannot delete</error></errors>`},
+			},
+		},
+	}
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	for _, tc := range tcs {
+
+		for _, resp := range tc.resp {
+			r := httptest.NewRecorder()
+			r.Header().Set("Content-Type", "application/xml")
+			r.WriteHeader(resp.code)
+			r.Write([]byte(resp.response))
+			responses[resp.key] = r
+		}
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader(tc.input.userInput)))
+		driver.SetServer(tp.URL)
+
+		act, _ := DeleteByCommand(driver, tc.input.searchCommand)
+
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("delete %v\n(%#v expected,  %#v found)", tc.input.searchCommand, e, act))
+		}
+	}
+}
+
+func TestDryRunInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	r := httptest.NewRecorder()
+	r.Header().Set("Content-Type", "application/xml")
+	r.WriteHeader(200)
+	r.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">53833</id><name>ceph5.np.ev1.example.com</name></node></nodes>`))
+	responses[resp_key{m: "GET", p: "/nodes.xml"}] = r
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(tp.URL)
+	driver.SetDryRun(true)
+
+	requests = requests[:0]
+
+	// --name ceph5.np.ev1.example.com --set avail_space=16348828 --dry-run
+	setCommand := &SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"ceph5.np.ev1.example.com"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{[]string{"avail_space=16348828"}}}
+	act, err := SetByCommand(driver, setCommand)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	for _, e := range []string{"PUT " + tp.URL + "/nodes/53833.xml", "id: 53833", "name: ceph5.np.ev1.example.com", "node[avail_space]=16348828"} {
+		assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+	}
+
+	// --name ceph5.np.ev1.example.com --delete --dry-run
+	setCommand.delete = true
+	act, err = DeleteByCommand(driver, setCommand)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	for _, e := range []string{"DELETE " + tp.URL + "/nodes/53833.xml", "id: 53833"} {
+		assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+	}
+
+	for _, req := range requests {
+		if req.URL.Path == "/accounts.xml" {
+			// login check
+			continue
+		}
+		assert.NotContains(t, []string{"PUT", "POST", "DELETE"}, req.Method, fmt.Sprintf("dry run sent %v %v", req.Method, req.URL))
+	}
+}
+
+func TestFormatResults(t *testing.T) {
+	hw := &ResultMap{Name: "hardware_profile"}
+	hw.Add("name", &ResultValue{Name: "name", Value: "HP ProLiant DL360 G7"})
+	ip1 := &ResultMap{Name: "ip_address"}
+	ip1.Add("address", &ResultValue{Name: "address", Value: "10.0.0.1"})
+	ip2 := &ResultMap{Name: "ip_address"}
+	ip2.Add("address", &ResultValue{Name: "address", Value: "10.0.0.2"})
+	node := &ResultMap{Name: "node"}
+	node.Add("name", &ResultValue{Name: "name", Value: "opsdb3.wc1.example.com"})
+	node.Add("id", &ResultValue{Name: "id", Value: "53833"})
+	node.Add("hardware_profile", hw)
+	node.Add("ip_addresses", &ResultArray{Name: "ip_addresses", Array: []Result{ip1, ip2}})
+	res := &ResultArray{Name: "nodes", Array: []Result{node}}
+
+	tcs := []struct {
+		output string
+		fields []string
+		exp    []string
+	}{
+		{OutputText, []string{"hardware_profile[name]"}, []string{"opsdb3.wc1.example.com:\n", "hardware_profile[name]: HP ProLiant DL360 G7"}},
+		{OutputJSON, []string{}, []string{`"name": "opsdb3.wc1.example.com",` + "\n" + `    "id": "53833"`, `"address": "10.0.0.2"`}},
+		{OutputYAML, []string{}, []string{"- name: opsdb3.wc1.example.com\n  id: \"53833\"\n", "  - address: 10.0.0.1\n"}},
+		{OutputCSV, []string{"hw", "ip_addresses[address]"}, []string{"name,hardware_profile[name],ip_addresses[address]\n", `opsdb3.wc1.example.com,HP ProLiant DL360 G7,"10.0.0.1,10.0.0.2"`}},
+		{OutputTSV, []string{}, []string{"name\tid\thardware_profile[name]\tip_addresses[address]\n", "opsdb3.wc1.example.com\t53833\t"}},
+	}
+
+	for _, tc := range tcs {
+		act, err := FormatResults(res, tc.fields, tc.output)
+		assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("output %v\n(%#v expected,  %#v found)", tc.output, e, act))
+		}
+	}
+
+	_, err := FormatResults(res, []string{}, "xml")
+	assert.NotNil(t, err, "unknown output format should be an error")
+}
+
+func TestFormatResultsWithTemplate(t *testing.T) {
+	ip1 := &ResultMap{Name: "ip_address"}
+	ip1.Add("address", &ResultValue{Name: "address", Value: "10.0.0.1"})
+	ip2 := &ResultMap{Name: "ip_address"}
+	ip2.Add("address", &ResultValue{Name: "address", Value: "10.0.0.2"})
+	osys := &ResultMap{Name: "operating_system"}
+	osys.Add("name", &ResultValue{Name: "name", Value: "CentOS 6.6"})
+	node1 := &ResultMap{Name: "node"}
+	node1.Add("name", &ResultValue{Name: "name", Value: "opsdb3.wc1.example.com"})
+	node1.Add("serial_number", &ResultValue{Name: "serial_number", Value: ""})
+	node1.Add("operating_system", osys)
+	node1.Add("ip_addresses", &ResultArray{Name: "ip_addresses", Array: []Result{ip1, ip2}})
+	node2 := &ResultMap{Name: "node"}
+	node2.Add("name", &ResultValue{Name: "name", Value: "opsdb4.wc1.example.com"})
+	res := &ResultArray{Name: "nodes", Array: []Result{node1, node2}}
+
+	tcs := []struct {
+		format string
+		exp    string
+	}{
+		{`{{.name}}`, "opsdb3.wc1.example.com\nopsdb4.wc1.example.com\n"},
+		{`{{.name}} {{.operating_system.name}}`, "opsdb3.wc1.example.com CentOS 6.6\nopsdb4.wc1.example.com \n"},
+		{`{{.name}} {{.serial_number}} {{index . "ip_addresses[address]"}}`, "opsdb3.wc1.example.com  [10.0.0.1 10.0.0.2]\nopsdb4.wc1.example.com  \n"},
+		{`{{.name}}{{if .operating_system.name}} {{.operating_system.name}}{{end}}`, "opsdb3.wc1.example.com CentOS 6.6\nopsdb4.wc1.example.com\n"},
+		{`{{join (index . "ip_addresses[address]") " "}} {{.name}}`, "10.0.0.1 10.0.0.2 opsdb3.wc1.example.com\n opsdb4.wc1.example.com\n"},
+		{`{{first (index . "ip_addresses[address]")}}`, "10.0.0.1\n"},
+		{`{{.serial_number | default "unknown"}}`, "unknown\nunknown\n"},
+	}
+
+	for _, tc := range tcs {
+		act, err := FormatResultsWithTemplate(res, tc.format)
+		assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+		assert.Equal(t, tc.exp, act, fmt.Sprintf("format %v", tc.format))
+	}
+
+	_, err := FormatResultsWithTemplate(res, `{{.name`)
+	assert.NotNil(t, err, "invalid template should be an error")
+
+	assert.Equal(t, []string{"ip_addresses[address]", "operating_system[name]"}, templateFields(`{{index . "ip_addresses[address]"}} {{.operating_system.name}} {{.name}}`))
+}
+
+func TestNodeGroupMembershipInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	nodesResp := `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">1</id><name>web1.example.com</name></node><node><id type="integer">2</id><name>web2.example.com</name></node></nodes>`
+	nodeGroupsResp := `<?xml version="1.0" encoding="UTF-8"?><node_groups type="array"><node_group><id type="integer">10</id><name>web</name></node_group></node_groups>`
+	assignmentsResp := `<?xml version="1.0" encoding="UTF-8"?><node_group_node_assignments type="array"><node_group_node_assignment><id type="integer">100</id><node_id type="integer">1</node_id><node_group_id type="integer">10</node_group_id><virtual_assignment type="boolean">false</virtual_assignment></node_group_node_assignment><node_group_node_assignment><id type="integer">101</id><node_id type="integer">2</node_id><node_group_id type="integer">10</node_group_id><virtual_assignment type="boolean">true</virtual_assignment></node_group_node_assignment></node_group_node_assignments>`
+	oneAssignmentResp := `<?xml version="1.0" encoding="UTF-8"?><node_group_node_assignments type="array"><node_group_node_assignment><id type="integer">100</id><node_id type="integer">1</node_id><node_group_id type="integer">10</node_group_id><virtual_assignment type="boolean">false</virtual_assignment></node_group_node_assignment></node_group_node_assignments>`
+
+	tcs := []struct {
+		setCommand *SetCommands
+		run        func(sc *SetCommands, d Driver) (string, error)
+		userInput  string
+		exp        []string
+		resp       []RSC
+	}{
+		// --name web --addtonodegroup web
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, addToNodeGroup: []string{"web"}},
+			(*SetCommands).AddToNodeGroupByCommand,
+			"y\n",
+			[]string{"web1.example.com is already a member of web, skipping.", "1 out of 1 assignment(s) succeeded."},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: nodesResp},
+				{key: resp_key{m: "GET", p: "/node_groups.xml"}, code: 200, response: nodeGroupsResp},
+				{key: resp_key{m: "GET", p: "/node_group_node_assignments.xml"}, code: 200, response: oneAssignmentResp},
+				{key: resp_key{m: "POST", p: "/node_group_node_assignments.xml"}, code: 201, response: ``},
+			},
+		},
+		// --name web --removefromnodegroup web
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, removeFromNodeGroup: []string{"web"}},
+			(*SetCommands).RemoveFromNodeGroupByCommand,
+			"y\n",
+			[]string{"web2.example.com is a member of web through a child group, skipping.", "1 out of 1 removal(s) succeeded."},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: nodesResp},
+				{key: resp_key{m: "GET", p: "/node_groups.xml"}, code: 200, response: nodeGroupsResp},
+				{key: resp_key{m: "GET", p: "/node_group_node_assignments.xml"}, code: 200, response: assignmentsResp},
+				{key: resp_key{m: "DELETE", p: "/node_group_node_assignments/100.xml"}, code: 200, response: ``},
+			},
+		},
+		// --name web --addtonodegroup doesnotexist
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes"}, setValueFlags: &SetValueFlags{}, addToNodeGroup: []string{"doesnotexist"}},
+			(*SetCommands).AddToNodeGroupByCommand,
+			"y\n",
+			[]string{"Node group 'doesnotexist' not found."},
+			[]RSC{
+				{key: resp_key{m: "GET", p: "/nodes.xml"}, code: 200, response: nodesResp},
+				{key: resp_key{m: "GET", p: "/node_groups.xml"}, code: 200, response: `<?xml version="1.0" encoding="UTF-8"?><nil_classes type="array"/>`},
+			},
+		},
+		// --createnodegroup web,db --yes
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes", yes: true}, setValueFlags: &SetValueFlags{}, createNodeGroup: []string{"web", "db"}},
+			(*SetCommands).CreateNodeGroupByCommand,
+			"",
+			[]string{"2 out of 2 creation(s) succeeded."},
+			[]RSC{
+				{key: resp_key{m: "POST", p: "/node_groups.xml"}, code: 201, response: ``},
+			},
+		},
+	}
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	for _, tc := range tcs {
+
+		for _, resp := range tc.resp {
+			r := httptest.NewRecorder()
+			r.Header().Set("Content-Type", "application/xml")
+			r.WriteHeader(resp.code)
+			r.Write([]byte(resp.response))
+			responses[resp.key] = r
+		}
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader(tc.userInput)))
+		driver.SetServer(tp.URL)
+
+		act, err := tc.run(tc.setCommand, driver)
+		if err != nil {
+			act += err.Error()
+		}
+
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+		}
+	}
+}
+
 type RSC struct {
 	key      resp_key
 	code     int
@@ -326,3 +622,1805 @@ func NewTestServer() *httptest.Server {
 
 	return ts
 }
+
+func TestNodeGroupMembersInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// node groups by name: web contains app and db, app contains db, loop contains loop2 which contains loop
+	nodeGroups := map[string]string{
+		"web":   `<node_group><id type="integer">1</id><name>web</name><real_nodes_names>web2.example.com,web1.example.com</real_nodes_names><virtual_nodes_names>db1.example.com,app1.example.com</virtual_nodes_names><nodes type="array"><node><name>web1.example.com</name></node><node><name>web2.example.com</name></node><node><name>app1.example.com</name></node><node><name>db1.example.com</name></node></nodes><child_groups type="array"><child_group><name>db</name></child_group><child_group><name>app</name></child_group></child_groups></node_group>`,
+		"app":   `<node_group><id type="integer">2</id><name>app</name><real_nodes_names>app1.example.com</real_nodes_names><virtual_nodes_names>db1.example.com</virtual_nodes_names><nodes type="array"><node><name>app1.example.com</name></node><node><name>db1.example.com</name></node></nodes><child_groups type="array"><child_group><name>db</name></child_group></child_groups></node_group>`,
+		"db":    `<node_group><id type="integer">3</id><name>db</name><real_nodes_names>db1.example.com</real_nodes_names><virtual_nodes_names></virtual_nodes_names><nodes type="array"><node><name>db1.example.com</name></node></nodes><child_groups type="array"/></node_group>`,
+		"loop":  `<node_group><id type="integer">4</id><name>loop</name><nodes type="array"/><child_groups type="array"><child_group><name>loop2</name></child_group></child_groups></node_group>`,
+		"loop2": `<node_group><id type="integer">5</id><name>loop2</name><nodes type="array"/><child_groups type="array"><child_group><name>loop</name></child_group></child_groups></node_group>`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if group, ok := nodeGroups[r.URL.Query().Get("exact_name")]; ok && r.URL.Path == "/node_groups.xml" {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><node_groups type="array">` + group + `</node_groups>`))
+		} else {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nil_classes type="array"/>`))
+		}
+	}))
+	defer ts.Close()
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(ts.URL)
+
+	act, err := NodeGroupByCommand(driver, &SearchCommands{nodeGroup: "web"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Child groups:\n  app\n  db\n====================\nReal Nodes:\n  web1.example.com\n  web2.example.com\n====================\nVirtual Nodes:\n  app1.example.com\n  db1.example.com\n", act)
+
+	act, err = NodeGroupExpandedByCommand(driver, &SearchCommands{nodeGroupNodes: []string{"app", "db"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "app1.example.com\ndb1.example.com\n", act)
+
+	act, err = NodeGroupExpandedByCommand(driver, &SearchCommands{nodeGroupNodes: []string{"web"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "app1.example.com\ndb1.example.com\nweb1.example.com\nweb2.example.com\n", act)
+
+	_, err = NodeGroupExpandedByCommand(driver, &SearchCommands{nodeGroupNodes: []string{"loop"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Node group cycle detected: loop -> loop2 -> loop")
+
+	_, err = NodeGroupByCommand(driver, &SearchCommands{nodeGroup: "doesnotexist"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Node group 'doesnotexist' not found.")
+}
+
+func TestGetSearchUrl(t *testing.T) {
+	tcs := []struct {
+		includes []string
+		exp      url.Values
+	}{
+		{[]string{}, url.Values{"exact_name": {"web1"}}},
+		{[]string{"status", "hardware_profile[name]"}, url.Values{"exact_name": {"web1"}, "include[status]": {""}, "include[hardware_profile]": {""}}},
+		{[]string{"node_groups[tags][name]", "node_groups[name]"}, url.Values{"exact_name": {"web1"}, "include[node_groups]": {"tags"}}},
+		{[]string{"node_groups[name]", "node_groups[tags][name]"}, url.Values{"exact_name": {"web1"}, "include[node_groups]": {"tags"}}},
+	}
+
+	for _, tc := range tcs {
+		u, err := url.Parse(getSearchUrl("http://nventory", "nodes", map[string][]string{"exact_": {"name=web1"}}, tc.includes))
+		assert.Nil(t, err)
+		assert.Equal(t, "/nodes.xml", u.Path)
+		assert.Equal(t, tc.exp, u.Query(), fmt.Sprintf("includes %v", tc.includes))
+	}
+}
+
+func TestNestedNodeGroupsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// web contains app, app contains db
+	nodeGroups := map[string]string{
+		"web": `<node_group><id type="integer">1</id><name>web</name><child_groups type="array"><child_group><id type="integer">2</id><name>app</name></child_group></child_groups></node_group>`,
+		"app": `<node_group><id type="integer">2</id><name>app</name><child_groups type="array"><child_group><id type="integer">3</id><name>db</name></child_group></child_groups></node_group>`,
+		"db":  `<node_group><id type="integer">3</id><name>db</name><child_groups type="array"/></node_group>`,
+	}
+	assignments := map[string]string{
+		"1": `<node_group_node_group_assignment><id type="integer">20</id><parent_id type="integer">1</parent_id><child_id type="integer">2</child_id></node_group_node_group_assignment>`,
+		"2": `<node_group_node_group_assignment><id type="integer">21</id><parent_id type="integer">2</parent_id><child_id type="integer">3</child_id></node_group_node_group_assignment>`,
+	}
+	changes := make([]string, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/node_groups.xml" && nodeGroups[r.URL.Query().Get("exact_name")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><node_groups type="array">` + nodeGroups[r.URL.Query().Get("exact_name")] + `</node_groups>`))
+		case r.Method == "GET" && r.URL.Path == "/node_group_node_group_assignments.xml" && assignments[r.URL.Query().Get("exact_parent_id")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><node_group_node_group_assignments type="array">` + assignments[r.URL.Query().Get("exact_parent_id")] + `</node_group_node_group_assignments>`))
+		case r.Method == "GET":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nil_classes type="array"/>`))
+		case r.URL.Path == "/accounts.xml":
+			w.WriteHeader(200)
+		default:
+			r.ParseForm()
+			changes = append(changes, fmt.Sprintf("%v %v %v", r.Method, r.URL.Path, r.Form.Encode()))
+			w.WriteHeader(201)
+		}
+	}))
+	defer ts.Close()
+
+	tcs := []struct {
+		setCommand *SetCommands
+		run        func(sc *SetCommands, d Driver) (string, error)
+		exp        []string
+		changes    []string
+	}{
+		// --addnodegrouptonodegroup db,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addNodeGroupToNodeGroup: []string{"db", "web"}},
+			(*SetCommands).AddNodeGroupToNodeGroupByCommand,
+			[]string{"1 out of 1 assignment(s) succeeded."},
+			[]string{"POST /node_group_node_group_assignments.xml node_group_node_group_assignment%5Bchild_id%5D=3&node_group_node_group_assignment%5Bparent_id%5D=1"},
+		},
+		// --addnodegrouptonodegroup app,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addNodeGroupToNodeGroup: []string{"app", "web"}},
+			(*SetCommands).AddNodeGroupToNodeGroupByCommand,
+			[]string{"app is already a child group of web, skipping."},
+			[]string{},
+		},
+		// --addnodegrouptonodegroup web,db
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addNodeGroupToNodeGroup: []string{"web", "db"}},
+			(*SetCommands).AddNodeGroupToNodeGroupByCommand,
+			[]string{"Adding web to db would create a node group cycle: db -> web -> app -> db"},
+			[]string{},
+		},
+		// --addnodegrouptonodegroup web,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addNodeGroupToNodeGroup: []string{"web", "web"}},
+			(*SetCommands).AddNodeGroupToNodeGroupByCommand,
+			[]string{"Adding web to web would create a node group cycle: web -> web"},
+			[]string{},
+		},
+		// --removenodegroupfromnodegroup app,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeNodeGroupFromNodeGroup: []string{"app", "web"}},
+			(*SetCommands).RemoveNodeGroupFromNodeGroupByCommand,
+			[]string{"1 out of 1 removal(s) succeeded."},
+			[]string{"DELETE /node_group_node_group_assignments/20.xml "},
+		},
+		// --removenodegroupfromnodegroup db,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeNodeGroupFromNodeGroup: []string{"db", "web"}},
+			(*SetCommands).RemoveNodeGroupFromNodeGroupByCommand,
+			[]string{"db is not a child group of web, skipping."},
+			[]string{},
+		},
+		// --removenodegroupfromnodegroup app
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeNodeGroupFromNodeGroup: []string{"app"}},
+			(*SetCommands).RemoveNodeGroupFromNodeGroupByCommand,
+			[]string{"--removenodegroupfromnodegroup expects child_group,parent_group."},
+			[]string{},
+		},
+	}
+
+	for _, tc := range tcs {
+		changes = changes[:0]
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+		driver.SetServer(ts.URL)
+
+		act, err := tc.run(tc.setCommand, driver)
+		if err != nil {
+			act += err.Error()
+		}
+
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+		}
+		assert.Equal(t, tc.changes, changes)
+	}
+}
+
+func TestTagsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// prod is a tag of app only
+	nodeGroups := map[string]string{
+		"web": `<node_group><id type="integer">1</id><name>web</name></node_group>`,
+		"app": `<node_group><id type="integer">2</id><name>app</name></node_group>`,
+	}
+	tags := map[string]string{
+		"prod": `<tag><id type="integer">7</id><name>prod</name></tag>`,
+	}
+	taggings := map[string]string{
+		"2,7": `<tagging><id type="integer">30</id><taggable_type>NodeGroup</taggable_type><taggable_id type="integer">2</taggable_id><tag_id type="integer">7</tag_id></tagging>`,
+	}
+	changes := make([]string, 0)
+	query := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		q := r.URL.Query()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/node_groups.xml" && nodeGroups[q.Get("exact_name")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><node_groups type="array">` + nodeGroups[q.Get("exact_name")] + `</node_groups>`))
+		case r.Method == "GET" && r.URL.Path == "/node_groups.xml" && q.Get("exact_tags[name]") == "prod":
+			query = r.URL.RawQuery
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><node_groups type="array">` + nodeGroups["app"] + `</node_groups>`))
+		case r.Method == "GET" && r.URL.Path == "/tags.xml" && tags[q.Get("exact_name")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><tags type="array">` + tags[q.Get("exact_name")] + `</tags>`))
+		case r.Method == "GET" && r.URL.Path == "/taggings.xml" && q.Get("exact_taggable_type") == "NodeGroup" && taggings[q.Get("exact_taggable_id")+","+q.Get("exact_tag_id")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><taggings type="array">` + taggings[q.Get("exact_taggable_id")+","+q.Get("exact_tag_id")] + `</taggings>`))
+		case r.Method == "GET":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nil_classes type="array"/>`))
+		case r.URL.Path == "/accounts.xml":
+			w.WriteHeader(200)
+		default:
+			r.ParseForm()
+			changes = append(changes, fmt.Sprintf("%v %v %v", r.Method, r.URL.Path, r.Form.Encode()))
+			if name := r.Form.Get("tag[name]"); r.URL.Path == "/tags.xml" && name != "lost" && tags[name] == "" {
+				tags[name] = `<tag><id type="integer">8</id><name>` + name + `</name></tag>`
+			}
+			w.WriteHeader(201)
+		}
+	}))
+	defer ts.Close()
+
+	tcs := []struct {
+		setCommand *SetCommands
+		run        func(sc *SetCommands, d Driver) (string, error)
+		dryRun     bool
+		exp        []string
+		changes    []string
+	}{
+		// --createtag prod,dev
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, createTag: []string{"prod", "dev"}},
+			(*SetCommands).CreateTagByCommand,
+			false,
+			[]string{"2 out of 2 creation(s) succeeded."},
+			[]string{"POST /tags.xml tag%5Bname%5D=prod", "POST /tags.xml tag%5Bname%5D=dev"},
+		},
+		// --addtagtonodegroup prod,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addTagToNodeGroup: []string{"prod", "web"}},
+			(*SetCommands).AddTagToNodeGroupByCommand,
+			false,
+			[]string{"1 out of 1 tagging(s) succeeded."},
+			[]string{"POST /taggings.xml tagging%5Btag_id%5D=7&tagging%5Btaggable_id%5D=1&tagging%5Btaggable_type%5D=NodeGroup"},
+		},
+		// --addtagtonodegroup prod,app
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addTagToNodeGroup: []string{"prod", "app"}},
+			(*SetCommands).AddTagToNodeGroupByCommand,
+			false,
+			[]string{"app is already tagged with prod, skipping."},
+			[]string{},
+		},
+		// --addtagtonodegroup new,web --dry-run
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addTagToNodeGroup: []string{"new", "web"}},
+			(*SetCommands).AddTagToNodeGroupByCommand,
+			true,
+			[]string{"POST " + ts.URL + "/tags.xml", "tag[name]=new", "POST " + ts.URL + "/taggings.xml", "tagging[tag_id]=(new)", "tagging[taggable_id]=1"},
+			[]string{},
+		},
+		// --addtagtonodegroup lost,web, the tag can't be found once created
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, addTagToNodeGroup: []string{"lost", "web"}},
+			(*SetCommands).AddTagToNodeGroupByCommand,
+			false,
+			[]string{"1 out of 1 creation(s) succeeded.", "Tag 'lost' not found after creating it."},
+			[]string{"POST /tags.xml tag%5Bname%5D=lost"},
+		},
+		// --removetagfromnodegroup prod,app
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeTagFromNodeGroup: []string{"prod", "app"}},
+			(*SetCommands).RemoveTagFromNodeGroupByCommand,
+			false,
+			[]string{"1 out of 1 removal(s) succeeded."},
+			[]string{"DELETE /taggings/30.xml "},
+		},
+		// --removetagfromnodegroup prod,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeTagFromNodeGroup: []string{"prod", "web"}},
+			(*SetCommands).RemoveTagFromNodeGroupByCommand,
+			false,
+			[]string{"web is not tagged with prod, skipping."},
+			[]string{},
+		},
+		// --removetagfromnodegroup missing,web
+		{
+			&SetCommands{searchCommand: &SearchCommands{yes: true}, removeTagFromNodeGroup: []string{"missing", "web"}},
+			(*SetCommands).RemoveTagFromNodeGroupByCommand,
+			false,
+			[]string{"Tag 'missing' not found."},
+			[]string{},
+		},
+	}
+
+	for _, tc := range tcs {
+		changes = changes[:0]
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+		driver.SetServer(ts.URL)
+		driver.SetDryRun(tc.dryRun)
+
+		act, err := tc.run(tc.setCommand, driver)
+		if err != nil {
+			act += err.Error()
+		}
+
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+		}
+		assert.Equal(t, tc.changes, changes)
+	}
+
+	// creating the tag and tagging the groups is confirmed once, for the
+	// groups that aren't tagged yet
+	prompted := func(answer string, tag string, nodeGroups ...string) (string, string, error) {
+		changes = changes[:0]
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader(answer)))
+		driver.SetServer(ts.URL)
+		var act string
+		var err error
+		prompts := captureStdout(t, func() {
+			act, err = driver.AddTagToNodeGroups(tag, nodeGroups, false)
+		})
+		return prompts, act, err
+	}
+	prompts, act, err := prompted("n\n", "fresh", "web")
+	assert.Nil(t, err)
+	assert.Equal(t, "This will create new tag (fresh) and tag 1 node group(s) with it, continue?  [y/N]: ", prompts)
+	assert.Equal(t, "Cancelled\n", act)
+	assert.Equal(t, []string{}, changes)
+	prompts, act, err = prompted("y\n", "fresh", "web")
+	assert.Nil(t, err)
+	assert.Equal(t, "This will create new tag (fresh) and tag 1 node group(s) with it, continue?  [y/N]: ", prompts)
+	assert.Equal(t, "1 out of 1 creation(s) succeeded.\n1 out of 1 tagging(s) succeeded.\n", act)
+	assert.Equal(t, []string{"POST /tags.xml tag%5Bname%5D=fresh", "POST /taggings.xml tagging%5Btag_id%5D=8&tagging%5Btaggable_id%5D=1&tagging%5Btaggable_type%5D=NodeGroup"}, changes)
+	prompts, _, err = prompted("y\n", "prod", "web", "app")
+	assert.Nil(t, err)
+	assert.Equal(t, "This will tag 1 node group(s) with prod, continue?  [y/N]: ", prompts)
+
+	// --objecttype node_groups --tag prod
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(ts.URL)
+	res, err := SearchByCommand(driver, &SearchCommands{searchFlags: &SearchFlags{Tag: "prod"}, objectType: "node_groups"})
+	assert.Nil(t, err)
+	assert.Equal(t, "exact_tags%5Bname%5D=prod", query)
+	assert.Equal(t, "app\n", PrintResultsFilterByFields(res, []string{}))
+}
+
+// captureStdout returns what run prints to stdout, like the prompts.
+func captureStdout(t *testing.T, run func()) string {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	run()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	return string(out)
+}
+
+func TestGraffitiInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// web1 has graffiti owner:alice, web2 has none
+	nodesResp := `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">1</id><name>web1.example.com</name><graffitis type="array"><graffiti><id type="integer">40</id><name>owner</name><value>alice</value></graffiti><graffiti><id type="integer">41</id><name>team</name><value>web</value></graffiti></graffitis></node><node><id type="integer">2</id><name>web2.example.com</name><graffitis type="array"/></node></nodes>`
+	graffitis := map[string]string{
+		"owner,1,Node": `<graffiti><id type="integer">40</id><name>owner</name><value>alice</value><graffitiable_id type="integer">1</graffitiable_id><graffitiable_type>Node</graffitiable_type></graffiti>`,
+	}
+	changes := make([]string, 0)
+	query := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		q := r.URL.Query()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/nodes.xml":
+			query = r.URL.RawQuery
+			w.Write([]byte(nodesResp))
+		case r.Method == "GET" && r.URL.Path == "/nodes/field_names.xml":
+			w.Write([]byte(`<field_names><field_name>name</field_name><field_name>graffitis[name] (graffitis)</field_name><field_name>graffitis[value]</field_name></field_names>`))
+		case r.Method == "GET" && r.URL.Path == "/graffitis.xml" && graffitis[q.Get("exact_name")+","+q.Get("exact_graffitiable_id")+","+q.Get("exact_graffitiable_type")] != "":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><graffitis type="array">` + graffitis[q.Get("exact_name")+","+q.Get("exact_graffitiable_id")+","+q.Get("exact_graffitiable_type")] + `</graffitis>`))
+		case r.Method == "GET":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nil_classes type="array"/>`))
+		case r.URL.Path == "/accounts.xml":
+			w.WriteHeader(200)
+		default:
+			r.ParseForm()
+			changes = append(changes, fmt.Sprintf("%v %v %v", r.Method, r.URL.Path, r.Form.Encode()))
+			w.WriteHeader(201)
+		}
+	}))
+	defer ts.Close()
+
+	tcs := []struct {
+		setCommand *SetCommands
+		run        func(sc *SetCommands, d Driver) (string, error)
+		exp        []string
+		changes    []string
+	}{
+		// --name web --addgraffiti owner:bob
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes", yes: true}, addGraffiti: "owner:bob"},
+			(*SetCommands).AddGraffitiByCommand,
+			[]string{"2 out of 2 graffiti update(s) succeeded."},
+			[]string{
+				"PUT /graffitis/40.xml graffiti%5Bvalue%5D=bob",
+				"POST /graffitis.xml graffiti%5Bgraffitiable_id%5D=2&graffiti%5Bgraffitiable_type%5D=Node&graffiti%5Bname%5D=owner&graffiti%5Bvalue%5D=bob",
+			},
+		},
+		// --name web --addgraffiti owner
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes", yes: true}, addGraffiti: "owner"},
+			(*SetCommands).AddGraffitiByCommand,
+			[]string{"Graffiti must be given as name:value."},
+			[]string{},
+		},
+		// --name web --deletegraffiti owner
+		{
+			&SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "nodes", yes: true}, deleteGraffiti: "owner"},
+			(*SetCommands).DeleteGraffitiByCommand,
+			[]string{"web2.example.com has no graffiti owner, skipping.", "1 out of 1 deletion(s) succeeded."},
+			[]string{"DELETE /graffitis/40.xml "},
+		},
+	}
+
+	for _, tc := range tcs {
+		changes = changes[:0]
+		driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+		driver.SetServer(ts.URL)
+
+		act, err := tc.run(tc.setCommand, driver)
+		if err != nil {
+			act += err.Error()
+		}
+
+		for _, e := range tc.exp {
+			assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+		}
+		assert.Equal(t, tc.changes, changes)
+	}
+
+	// --name web --fields graffiti
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(ts.URL)
+	sc := &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}, Fields: []string{"graffiti"}}, objectType: "nodes"}
+	res, err := SearchByCommand(driver, sc)
+	assert.Nil(t, err)
+	assert.Contains(t, query, "include%5Bgraffitis%5D=")
+	assert.Equal(t, "web1.example.com:\ngraffiti[owner]: alice\ngraffiti[team]: web\n\nweb2.example.com:\n\n", PrintResultsFilterByFields(res, sc.GetFieldsArray()))
+	assert.Equal(t, "web1.example.com:\ngraffiti[team]: web\n\nweb2.example.com:\n\n", PrintResultsFilterByFields(res, []string{"graffiti[team]"}))
+}
+
+func TestAddCommentInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	for _, resp := range []RSC{
+		{key: resp_key{m: "GET", p: "/node_groups.xml"}, code: 200, response: `<?xml version="1.0" encoding="UTF-8"?><node_groups type="array"><node_group><id type="integer">10</id><name>web</name></node_group><node_group><id type="integer">11</id><name>web-canary</name></node_group></node_groups>`},
+		{key: resp_key{m: "POST", p: "/comments.xml"}, code: 201, response: ``},
+	} {
+		r := httptest.NewRecorder()
+		r.Header().Set("Content-Type", "application/xml")
+		r.WriteHeader(resp.code)
+		r.Write([]byte(resp.response))
+		responses[resp.key] = r
+	}
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("y\n")))
+	driver.SetServer(tp.URL)
+
+	requests = requests[:0]
+
+	// --objecttype node_groups --name web --addcomment "moved to new rack"
+	setCommand := &SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{Name: []string{"web"}}, objectType: "node_groups"}, addComment: "moved to new rack"}
+	act, err := setCommand.AddCommentByCommand(driver)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	for _, e := range []string{"Commenting as ", "2 out of 2 comment(s) succeeded."} {
+		assert.Equal(t, strings.Contains(act, e), true, fmt.Sprintf("(%#v expected,  %#v found)", e, act))
+	}
+
+	posted := make([]string, 0)
+	for _, req := range requests {
+		if req.Method == "POST" && req.URL.Path == "/comments.xml" {
+			posted = append(posted, req.URL.RawQuery)
+		}
+	}
+	assert.Equal(t, []string{
+		"comment%5Bcomment%5D=moved+to+new+rack&comment%5Bcommentable_id%5D=10&comment%5Bcommentable_type%5D=NodeGroup",
+		"comment%5Bcomment%5D=moved+to+new+rack&comment%5Bcommentable_id%5D=11&comment%5Bcommentable_type%5D=NodeGroup",
+	}, posted)
+
+	// --objecttype node_groups --name web --addcomment ""
+	setCommand.addComment = ""
+	_, err = setCommand.AddCommentByCommand(driver)
+	assert.NotNil(t, err)
+}
+
+func TestGetFieldNamesInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(tp.URL)
+
+	// --getfieldnames
+	fields, err := GetFieldNamesByCommand(driver, &SearchCommands{objectType: "nodes"})
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	byName := make(map[string]FieldName, 0)
+	for _, field := range fields {
+		byName[field.Name] = field
+	}
+	assert.Equal(t, FieldName{Name: "name", Shortcuts: []string{}}, byName["name"])
+	assert.Equal(t, FieldName{Name: "serial_number", Shortcuts: []string{"serial"}}, byName["serial_number"])
+	assert.Equal(t, FieldName{Name: "hardware_profile[name]", Shortcuts: []string{"hardware_profile", "hw"}, Nested: true, Association: "hardware_profile"}, byName["hardware_profile[name]"])
+	assert.Equal(t, FieldName{Name: "hardware_profile[model]", Shortcuts: []string{"hwmodel"}, Nested: true, Association: "hardware_profile"}, byName["hardware_profile[model]"])
+	assert.Equal(t, "hardware_profile[name] (hardware_profile, hw)", byName["hardware_profile[name]"].String())
+
+	// --getfieldnames --output csv
+	act, err := FormatResults(FieldNamesToResult([]FieldName{byName["name"], byName["hardware_profile[name]"]}), []string{}, OutputCSV)
+	assert.Nil(t, err)
+	assert.Equal(t, "name,shortcuts,nested,association\nname,,false,\nhardware_profile[name],\"hardware_profile,hw\",true,hardware_profile\n", act)
+
+	// --getfieldnames --objecttype doesnotexist
+	_, err = GetFieldNamesByCommand(driver, &SearchCommands{objectType: "doesnotexist"})
+	assert.NotNil(t, err)
+}
+
+func TestGetAllValuesInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tp := NewTestServer()
+
+	initResponses()
+
+	r := httptest.NewRecorder()
+	r.Header().Set("Content-Type", "application/xml")
+	r.WriteHeader(200)
+	r.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nodes type="array">` +
+		`<node><id type="integer">1</id><name>web1</name><operating_system><name>CentOS 6</name></operating_system><ip_addresses type="array"><ip_address><address>10.0.0.1</address></ip_address><ip_address><address>10.0.0.2</address></ip_address></ip_addresses></node>` +
+		`<node><id type="integer">2</id><name>web2</name><operating_system><name>CentOS 7</name></operating_system><ip_addresses type="array"><ip_address><address>10.0.0.3</address></ip_address></ip_addresses></node>` +
+		`<node><id type="integer">3</id><name>web3</name><operating_system><name>CentOS 7</name></operating_system><ip_addresses type="array"><ip_address><address>10.0.0.3</address></ip_address><ip_address><address>10.0.0.3</address></ip_address></ip_addresses></node>` +
+		`</nodes>`))
+	responses[resp_key{m: "GET", p: "/nodes.xml"}] = r
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(tp.URL)
+
+	tcs := []struct {
+		searchCommand *SearchCommands
+		exp           string
+	}{
+		// --getallvalues os,ip
+		{
+			&SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes", allValues: []string{"os,ip_addresses[address]"}, sortValues: SortByValue},
+			"os:\n  CentOS 6\n  CentOS 7\n\nip_addresses[address]:\n  10.0.0.1\n  10.0.0.2\n  10.0.0.3\n\n",
+		},
+		// --getallvalues os,ip --count --sortby count
+		{
+			&SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes", allValues: []string{"os", "ip_addresses[address]"}, countValues: true, sortValues: SortByCount},
+			"os:\n  CentOS 7 (2)\n  CentOS 6 (1)\n\nip_addresses[address]:\n  10.0.0.3 (2)\n  10.0.0.1 (1)\n  10.0.0.2 (1)\n\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		requests = requests[:0]
+		values, err := GetAllValuesByCommand(driver, tc.searchCommand)
+		assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+		assert.Equal(t, tc.exp, PrintAllValues(values, tc.searchCommand.IsCountValues()))
+		for _, req := range requests {
+			if req.URL.Path == "/nodes.xml" {
+				assert.Contains(t, req.URL.RawQuery, "include%5Boperating_system%5D=")
+				assert.Contains(t, req.URL.RawQuery, "include%5Bip_addresses%5D=")
+			}
+		}
+	}
+
+	// --getallvalues os --count --output csv
+	values, err := GetAllValuesByCommand(driver, &SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes", allValues: []string{"os"}, sortValues: SortByValue})
+	assert.Nil(t, err)
+	act, err := FormatResults(AllValuesToResult(values, true), []string{}, OutputCSV)
+	assert.Nil(t, err)
+	assert.Equal(t, "field,value,count\nos,CentOS 6,1\nos,CentOS 7,2\n", act)
+
+	// --getallvalues os --sortby name
+	_, err = GetAllValuesByCommand(driver, &SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes", allValues: []string{"os"}, sortValues: "name"})
+	assert.NotNil(t, err)
+}
+
+func writeFixtures(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := root + name
+		assert.Nil(t, os.MkdirAll(p[:strings.LastIndex(p, "/")], 0755))
+		assert.Nil(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func TestRegisterHostInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	root, err := ioutil.TempDir("", "nvregister")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	writeFixtures(t, root, map[string]string{
+		"/proc/sys/kernel/hostname":   "web01\n",
+		"/proc/sys/kernel/domainname": "(none)\n",
+		"/etc/resolv.conf":            "search example.com\nnameserver 10.0.0.2\n",
+		"/etc/os-release":             "NAME=\"CentOS Linux\"\nVERSION_ID=\"7\"\n",
+		"/proc/sys/kernel/osrelease":  "3.10.0-514.el7.x86_64\n",
+		"/proc/cpuinfo": "processor\t: 0\nmodel name\t: Intel(R) Xeon(R) CPU E5-2650 0 @ 2.00GHz\nphysical id\t: 0\ncore id\t\t: 0\n\n" +
+			"processor\t: 1\nmodel name\t: Intel(R) Xeon(R) CPU E5-2650 0 @ 2.00GHz\nphysical id\t: 0\ncore id\t\t: 1\n\n" +
+			"processor\t: 2\nmodel name\t: Intel(R) Xeon(R) CPU E5-2650 0 @ 2.00GHz\nphysical id\t: 1\ncore id\t\t: 0\n",
+		"/proc/meminfo":                   "MemTotal:       16336588 kB\nSwapTotal:       2097148 kB\n",
+		"/proc/modules":                   "ext4 123 1 - Live 0x0\n",
+		"/sys/class/net/lo/address":       "00:00:00:00:00:00\n",
+		"/sys/class/net/eth0/address":     "00:1a:4b:5c:6d:7e\n",
+		"/sys/class/net/eth0/type":        "1\n",
+		"/sys/class/net/eth0/operstate":   "up\n",
+		"/sys/class/net/eth0/speed":       "1000\n",
+		"/sys/class/net/eth0/duplex":      "full\n",
+		"/etc/fstab":                      "/dev/sda1 / ext4 defaults 1 1\nfiler1:/vol/home /home nfs rw 0 0\n",
+		"/etc/exports":                    "/export/data 10.0.0.0/24(rw)\n",
+	})
+	writeFixtures(t, root+"/commands", map[string]string{
+		"/uname_-m": "x86_64\n",
+		"/dmidecode": "# dmidecode 2.12\n\nHandle 0x0001, DMI type 1, 27 bytes\nSystem Information\n\tManufacturer: Dell Inc.\n\tProduct Name: PowerEdge R620\n\tSerial Number: ABC1234\n\tUUID: 4C4C4544-0042-4310-8033-C4C04F333132\n\n" +
+			"Handle 0x1100, DMI type 17, 34 bytes\nMemory Device\n\tSize: 8192 MB\n\tForm Factor: DIMM\n\tLocator: DIMM_A1\n\n" +
+			"Handle 0x1101, DMI type 17, 34 bytes\nMemory Device\n\tSize: 8 GB\n\tForm Factor: DIMM\n\tLocator: DIMM_A2\n\n" +
+			"Handle 0x1102, DMI type 17, 34 bytes\nMemory Device\n\tSize: No Module Installed\n\tForm Factor: DIMM\n\tLocator: DIMM_A3\n",
+		"/ip_-o_-4_addr_show":  "1: lo    inet 127.0.0.1/8 scope host lo\n2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\n",
+		"/lldpctl_-f_keyvalue": "lldp.eth0.chassis.name=sw01.example.com\nlldp.eth0.port.ifname=GigabitEthernet1/0/12\n",
+		"/df_-k":               "Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/sda1 100 40 60 40% /\n/dev/sda2 200 50 150 25% /home\n/dev/sda3 300 100 200 33% /var\n",
+	})
+	h := &HostInfo{Root: root, Run: FixtureRunner(root + "/commands"), Collectors: DefaultCollectors(false, false)}
+
+	data, err := h.Gather()
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	for k, v := range map[string]string{
+		"name":                                             "web01.example.com",
+		"operating_system[variant]":                        "CentOS Linux",
+		"operating_system[version_number]":                 "7",
+		"operating_system[architecture]":                   "x86_64",
+		"kernel_version":                                   "3.10.0-514.el7.x86_64",
+		"os_processor_count":                               "3",
+		"processor_count":                                  "2",
+		"processor_core_count":                             "3",
+		"processor_manufacturer":                           "Intel",
+		"processor_model":                                  "Xeon(R) CPU E5-2650 0",
+		"processor_speed":                                  "2.00GHz",
+		"os_memory":                                        "15.58 GB",
+		"swap":                                             "2.00 GB",
+		"hardware_profile[manufacturer]":                   "Dell Inc.",
+		"hardware_profile[model]":                          "PowerEdge R620",
+		"serial_number":                                    "ABC1234",
+		"physical_memory":                                  "16.00 GB",
+		"uniqueid":                                         "4C4C4544-0042-4310-8033-C4C04F333132",
+		"network_interfaces[eth0][hardware_address]":       "00:1a:4b:5c:6d:7e",
+		"network_interfaces[eth0][interface_type]":         "Ethernet",
+		"network_interfaces[eth0][speed]":                  "1000",
+		"network_interfaces[eth0][full_duplex]":            "1",
+		"network_interfaces[eth0][ip_addresses][0][address]": "10.0.0.5",
+		"network_interfaces[eth0][ip_addresses][0][netmask]": "255.255.255.0",
+		"network_interfaces[eth0][switch]":                 "sw01.example.com",
+		"network_interfaces[eth0][port]":                   "1/0/12",
+		"network_interfaces[authoritative]":                "true",
+		"used_space":                                       "90",
+		"avail_space":                                      "210",
+		"volumes[mounted][/home][volume_server]":           "filer1",
+		"volumes[served][/export/data][type]":              "nfs",
+	} {
+		assert.Equal(t, v, data[k], k)
+	}
+	_, ok := data["network_interfaces[lo][name]"]
+	assert.False(t, ok, "loopback shouldn't be registered")
+
+	// --register --no-switchport --no-storage
+	h.Collectors = DefaultCollectors(true, true)
+	data, err = h.Gather()
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	for _, k := range []string{"network_interfaces[eth0][switch]", "network_interfaces[eth0][port]", "used_space", "volumes[mounted][/home][type]"} {
+		_, ok := data[k]
+		assert.False(t, ok, k)
+	}
+
+	// The node is found by its reversed uniqueid and updated, a node that
+	// isn't found at all is created.
+	changes := make([]string, 0)
+	tp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if r.Method != "GET" {
+			if r.URL.Path != "/accounts.xml" {
+				changes = append(changes, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("node[name]")+" "+r.URL.Query().Get("hardware_profile[model]"))
+			}
+			w.WriteHeader(200)
+			return
+		}
+		if r.URL.Query().Get("exact_uniqueid") == "44454C4C-4200-1043-8033-C4C04F333132" {
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">5</id><name>old-web01.example.com</name></node></nodes>`)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"></nodes>`)
+	}))
+	defer tp.Close()
+
+	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+	act, err := driver.Register(data)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	assert.Equal(t, strings.Contains(act, "1 out of 1 registration(s) succeeded."), true, act)
+
+	data["uniqueid"] = "00000000-0000-0000-0000-000000000000"
+	_, err = driver.Register(data)
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	assert.Equal(t, []string{
+		"PUT /nodes/5.xml web01.example.com PowerEdge R620",
+		"POST /nodes.xml web01.example.com PowerEdge R620",
+	}, changes)
+}
+
+type rackCollector struct{}
+
+func (c *rackCollector) Name() string { return "rack" }
+
+func (c *rackCollector) Collect(h *HostInfo, data map[string]string) error {
+	rack, err := h.ReadFile("/etc/rack")
+	if err != nil {
+		return err
+	}
+	data["rack"] = rack
+	return nil
+}
+
+func TestCollectorsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	root, err := ioutil.TempDir("", "nvcollectors")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	writeFixtures(t, root, map[string]string{
+		"/proc/sys/kernel/hostname":         "kvm01.example.com\n",
+		"/proc/modules":                     "kvm_intel 170086 0 - Live 0x0\nkvm 566340 1 kvm_intel, Live 0x0\n",
+		"/var/lib/libvirt/images/vm01.img":  strings.Repeat("x", 4096),
+		"/sys/class/net/eth1/address":       "00:1a:4b:5c:6d:7f\n",
+		"/sys/class/net/eth0/address":       "00:1a:4b:5c:6d:7e\n",
+		"/etc/rack":                         "R12\n",
+		"/commands/dmidecode":               "Handle 0x0001, DMI type 1, 27 bytes\nSystem Information\n\tManufacturer: Dell Inc.\n\tProduct Name: C6100\n\tUUID: 4C4C4544-0042-4310-8033-C4C04F333132\n",
+		"/commands/virsh_list_--all":        " Id    Name                           State\n----------------------------------------------------\n 1     vm01                           running\n -     vm02                           shut off\n",
+		"/commands/virsh_dumpxml_vm01":      "<domain>\n  <source file='/var/lib/libvirt/images/vm01.img'/>\n</domain>\n",
+	})
+
+	// Only some of the collectors and a site-specific one, /proc/cpuinfo
+	// is missing from the fixture so the cpu collector fails without
+	// stopping the others.
+	h := &HostInfo{Root: root, Run: FixtureRunner(root + "/commands"), Collectors: []Collector{&CPUCollector{}, &DMICollector{}, &NICCollector{NoSwitchport: true}, &KVMCollector{}, &rackCollector{}}}
+	data, err := h.Gather()
+	assert.Nil(t, err, fmt.Sprintf("Error: %v", err))
+	assert.Equal(t, map[string]string{
+		"name":                                       "kvm01.example.com",
+		"hardware_profile[manufacturer]":             "Dell Inc.",
+		"hardware_profile[model]":                    "C6100",
+		"network_interfaces[eth0][name]":             "eth0",
+		"network_interfaces[eth0][hardware_address]": "00:1a:4b:5c:6d:7e",
+		"network_interfaces[eth1][name]":             "eth1",
+		"network_interfaces[eth1][hardware_address]": "00:1a:4b:5c:6d:7f",
+		"network_interfaces[authoritative]":          "true",
+		"virtualmode":                                "host",
+		"virtualarch":                                "kvm",
+		"vmguest[vm01][vmimg_size]":                  "4",
+		"rack":                                       "R12",
+		// Dell C6100s share their UUID, so the MAC of the first NIC is used
+		"uniqueid": "00:1a:4b:5c:6d:7e",
+	}, data)
+
+	// CentOS 6 has no /etc/os-release
+	writeFixtures(t, root, map[string]string{
+		"/proc/sys/kernel/osrelease": "2.6.32-696.el6.x86_64\n",
+		"/commands/uname_-m":         "x86_64\n",
+	})
+	data = map[string]string{}
+	assert.Nil(t, (&OSReleaseCollector{}).Collect(h, data))
+	assert.Equal(t, map[string]string{
+		"operating_system[architecture]": "x86_64",
+		"kernel_version":                 "2.6.32-696.el6.x86_64",
+	}, data)
+
+	// the switch port of eth0 is in the LLDP frames saved by
+	// systemd-networkd, the one of eth1 only known to lldpctl
+	tlv := func(tlvType byte, value string) string {
+		return string([]byte{tlvType<<1 | byte(len(value)>>8), byte(len(value))}) + value
+	}
+	lldpFrames := func(portID string) string {
+		frame := strings.Repeat("\x00", 14) + tlv(1, "\x04\x00\x1b\x2c\x3d\x4e\x5f") + tlv(2, portID) + tlv(3, "\x00\x78") +
+			tlv(4, "GigabitEthernet1/0/4") + tlv(5, "sw02.example.com") + tlv(0, "")
+		size := make([]byte, 8)
+		binary.LittleEndian.PutUint64(size, uint64(len(frame)))
+		return string(size) + frame
+	}
+	writeFixtures(t, root, map[string]string{
+		"/sys/class/net/eth0/ifindex":   "2\n",
+		"/sys/class/net/eth1/ifindex":   "3\n",
+		"/run/systemd/netif/lldp/2":     lldpFrames("\x05Gi1/0/3"),
+		"/commands/lldpctl_-f_keyvalue": "lldp.eth1.chassis.name=sw03.example.com\nlldp.eth1.port.ifname=Gi2/0/1\n",
+		"/commands/ip_-o_-4_addr_show":  "",
+	})
+	data = map[string]string{}
+	assert.Nil(t, (&NICCollector{}).Collect(h, data))
+	for k, v := range map[string]string{
+		"network_interfaces[eth0][switch]": "sw02.example.com",
+		"network_interfaces[eth0][port]":   "1/0/3",
+		"network_interfaces[eth1][switch]": "sw03.example.com",
+		"network_interfaces[eth1][port]":   "2/0/1",
+	} {
+		assert.Equal(t, v, data[k], k)
+	}
+	// the port description is the port when the port id is a MAC address
+	switchName, port := parseLLDPFrames([]byte(lldpFrames("\x03\x00\x1b\x2c\x3d\x4e\x60")))
+	assert.Equal(t, "sw02.example.com", switchName)
+	assert.Equal(t, "GigabitEthernet1/0/4", port)
+	switchName, _ = parseLLDPFrames([]byte(lldpFrames("\x05Gi1/0/3")[:20]))
+	assert.Equal(t, "", switchName)
+}
+
+func TestErrorKindsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	tp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		switch r.URL.Path {
+		case "/accounts.xml":
+			w.WriteHeader(200)
+		case "/nodes.xml":
+			switch r.URL.Query().Get("name") {
+			case "forbidden":
+				w.WriteHeader(403)
+			case "broken":
+				w.WriteHeader(500)
+			case "garbage":
+				fmt.Fprint(w, `this isn't xml`)
+			default:
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><nodes type="array"><node><id type="integer">1</id><name>web1</name></node><node><id type="integer">2</id><name>web2</name></node></nodes>`)
+			}
+		case "/nodes/1.xml":
+			w.WriteHeader(200)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer tp.Close()
+
+	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+	client := driver.nventoryClient
+
+	for name, kind := range map[string]error{"forbidden": ErrAuth, "broken": ErrServer, "garbage": ErrParse} {
+		_, err := client.getObjects("nodes", Conditions{"": []string{"name=" + name}}, []string{})
+		assert.Equal(t, kind, ErrorKind(err), fmt.Sprintf("%v: %v", name, err))
+	}
+
+	_, err := driver.GetFieldNames("no_such_objects")
+	assert.Equal(t, ErrNotFound, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	// web2 can't be deleted
+	_, err = client.DeleteObjects("nodes", Conditions{"": []string{"name=web"}}, []string{}, "user", true)
+	assert.Equal(t, ErrPartialUpdate, ErrorKind(err), fmt.Sprintf("%v", err))
+	assert.Equal(t, "1 out of 2 deletion(s) failed.\n", err.Error())
+
+	setCommand := &SetCommands{searchCommand: &SearchCommands{searchFlags: &SearchFlags{}, objectType: "nodes"}, setValueFlags: &SetValueFlags{value: []string{"status"}}}
+	_, err = setCommand.SetByCommand(driver)
+	assert.Equal(t, ErrUsage, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	assert.Nil(t, ErrorKind(fmt.Errorf("not an nvclient error")))
+}
+
+func TestContextInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// the server never answers searches, until the test is over
+	done := make(chan struct{})
+	tp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/accounts.xml" {
+			w.WriteHeader(200)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer tp.Close()
+	defer close(done)
+
+	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := driver.SearchContext(ctx, "nodes", map[string][]string{"": []string{"name=web"}}, []string{}, []string{})
+	assert.Equal(t, ErrCanceled, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	// the SIGINT handler cancels the base context
+	base, cancelBase := context.WithCancel(context.Background())
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+	driver.SetBaseContext(base)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancelBase()
+	}()
+	_, err = driver.GetAllSubsystemNames("nodes")
+	assert.Equal(t, ErrCanceled, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	// logging in is canceled too
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+	driver.SetBaseContext(base)
+	_, err = driver.GetHttpClientFor(autoreg)
+	assert.Equal(t, ErrCanceled, ErrorKind(err), fmt.Sprintf("%v", err))
+	_, err = driver.GetAllFields("nodes", map[string][]string{}, []string{}, []string{})
+	assert.Equal(t, ErrCanceled, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer(tp.URL)
+	driver.SetTimeout(50 * time.Millisecond)
+	_, err = driver.Search("nodes", map[string][]string{"": []string{"name=web"}}, []string{}, []string{})
+	assert.Equal(t, ErrServer, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	// Ctrl-C answers the prompts
+	input, _ := io.Pipe()
+	base, cancelBase = context.WithCancel(context.Background())
+	driver = NewNventoryDriver(bufio.NewReader(input))
+	driver.SetServer(tp.URL)
+	driver.SetBaseContext(base)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancelBase()
+	}()
+	var msg string
+	captureStdout(t, func() {
+		msg, err = driver.CreateTags([]string{"prod"}, false)
+	})
+	assert.Equal(t, "Cancelled\n", msg)
+	assert.Equal(t, ErrCanceled, ErrorKind(err), fmt.Sprintf("%v", err))
+
+	// --timeout takes the same values as timeout in the config file
+	for _, tc := range []struct {
+		arg string
+		exp time.Duration
+	}{{"30", 30 * time.Second}, {"2m", 2 * time.Minute}, {"0", 0}} {
+		sc := NewSearchCommand(&SearchFlags{}, driver)
+		app := &cobra.Command{}
+		sc.InitializeCommand(app)
+		assert.Nil(t, app.ParseFlags([]string{"--timeout", tc.arg}))
+		assert.Equal(t, tc.exp, sc.GetTimeout(), "--timeout "+tc.arg)
+
+		d, err := ParseTimeout(tc.arg)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.exp, d)
+	}
+	app := &cobra.Command{}
+	NewSearchCommand(&SearchFlags{}, driver).InitializeCommand(app)
+	assert.NotNil(t, app.ParseFlags([]string{"--timeout", "soon"}))
+}
+
+func TestTLSInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	clientCerts := 0
+	tp := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts = len(r.TLS.PeerCertificates)
+		if r.URL.Path == "/accounts.xml" {
+			w.WriteHeader(200)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><field_names type="array"><field_name>name</field_name><field_name>status[name]</field_name></field_names>`)
+	}))
+	tp.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	tp.StartTLS()
+	defer tp.Close()
+
+	dir, err := ioutil.TempDir("", "nvtls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	serverCert := tp.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	assert.Nil(t, err)
+	writeFixtures(t, dir, map[string]string{
+		"/ca.pem":       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]})),
+		"/key.pem":      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+		"/certs/a.pem":  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]})),
+		"/certs/README": "not a certificate",
+	})
+
+	testCases := []struct {
+		name  string
+		opts  TLSOptions
+		kind  error
+		certs int
+	}{
+		{"verified by default", TLSOptions{}, ErrServer, 0},
+		{"missing ca_file is ignored", TLSOptions{CAFile: filepath.Join(dir, "missing.pem")}, ErrServer, 0},
+		{"ca_file", TLSOptions{CAFile: filepath.Join(dir, "ca.pem")}, nil, 0},
+		{"ca_path", TLSOptions{CAPath: filepath.Join(dir, "certs")}, nil, 0},
+		{"insecure", TLSOptions{Insecure: true}, nil, 0},
+		{"client certificate", TLSOptions{CAFile: filepath.Join(dir, "ca.pem"), ClientCert: filepath.Join(dir, "ca.pem"), ClientKey: filepath.Join(dir, "key.pem")}, nil, 1},
+	}
+	for _, tc := range testCases {
+		clientCerts = 0
+		driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+		driver.SetServer(tp.URL)
+		assert.Nil(t, driver.SetTLSOptions(tc.opts), tc.name)
+		names, err := driver.GetAllSubsystemNames("nodes")
+		assert.Equal(t, tc.kind, ErrorKind(err), fmt.Sprintf("%v: %v", tc.name, err))
+		if tc.kind == nil {
+			assert.Equal(t, []string{"status"}, names, tc.name)
+		}
+		assert.Equal(t, tc.certs, clientCerts, tc.name)
+	}
+
+	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+	err = driver.SetTLSOptions(TLSOptions{ClientCert: filepath.Join(dir, "ca.pem")})
+	assert.Equal(t, ErrUsage, ErrorKind(err))
+	err = driver.SetTLSOptions(TLSOptions{CAFile: filepath.Join(dir, "key.pem")})
+	assert.Equal(t, ErrUsage, ErrorKind(err))
+}
+
+func TestProxyInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	proxied := make([]string, 0)
+	tp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		if r.URL.Path == "/accounts.xml" {
+			w.WriteHeader(200)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><field_names type="array"><field_name>name</field_name><field_name>status[name]</field_name></field_names>`)
+	}))
+	defer tp.Close()
+
+	for _, env := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(env, "")
+	}
+
+	// no proxy, nventory.invalid can't be reached
+	driver := NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer("http://nventory.invalid")
+	_, err := driver.GetAllSubsystemNames("nodes")
+	assert.Equal(t, ErrServer, ErrorKind(err), fmt.Sprintf("%v", err))
+	assert.Equal(t, []string{}, proxied)
+
+	// --proxy or proxy_server, logging in goes through the proxy too
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer("http://nventory.invalid")
+	assert.Nil(t, driver.SetProxy(tp.URL))
+	names, err := driver.GetAllSubsystemNames("nodes")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"status"}, names)
+	assert.Equal(t, []string{"POST http://nventory.invalid/accounts.xml", "GET http://nventory.invalid/nodes/field_names.xml"}, proxied)
+
+	// HTTP_PROXY, unless the server is in NO_PROXY
+	proxied = make([]string, 0)
+	t.Setenv("HTTP_PROXY", tp.URL)
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer("http://nventory.invalid")
+	_, err = driver.GetAllSubsystemNames("nodes")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(proxied))
+
+	proxied = make([]string, 0)
+	t.Setenv("NO_PROXY", ".invalid")
+	driver = NewNventoryDriver(bufio.NewReader(os.Stdin))
+	driver.SetServer("http://nventory.invalid")
+	assert.Nil(t, driver.SetProxy(tp.URL))
+	_, err = driver.GetAllSubsystemNames("nodes")
+	assert.Equal(t, ErrServer, ErrorKind(err), fmt.Sprintf("%v", err))
+	assert.Equal(t, []string{}, proxied)
+
+	assert.Equal(t, ErrUsage, ErrorKind(driver.SetProxy("http://proxy\n.example.com:8080")))
+}
+
+func TestConfigFilesInNventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeFixtures(t, dir, map[string]string{
+		"/etc/nventory.conf": "# shared with the ruby client\n" +
+			"server = http://nventory.example.com/\n" +
+			"ca_file = /etc/nventory/ca.pem\n" +
+			"proxy_server = http://proxy.example.com:8080\n" +
+			"\n" +
+			"not a setting\n",
+		"/home/.nventory.conf": "  server=http://nventory2.example.com\n" +
+			"proxy_server = http://proxy2.example.com:8080\n" +
+			"sso_server = https://login.example.com/\n" +
+			"cookiefile = /home/me/.nventory_cookie\n",
+		"/other.conf": "sso_server = https://login2.example.com/\nca_path = /etc/ssl/certs\n",
+	})
+
+	settings, sources, err := ReadConfigFiles(dir+"/etc/nventory.conf", dir+"/home/.nventory.conf", dir+"/missing.conf", dir+"/other.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"server":       "http://nventory2.example.com",
+		"ca_file":      "/etc/nventory/ca.pem",
+		"ca_path":      "/etc/ssl/certs",
+		"proxy_server": "http://proxy.example.com:8080",
+		"sso_server":   "https://login.example.com/",
+		"cookiefile":   "/home/me/.nventory_cookie",
+	}, settings)
+	assert.Equal(t, dir+"/home/.nventory.conf", sources["server"])
+	assert.Equal(t, dir+"/etc/nventory.conf", sources["proxy_server"])
+
+	// sso_server replaces the https://sso* guess
+	c := NewHttpClient()
+	assert.True(t, c.isSSOLocation("https://sso.example.com/login?url=http://nventory", ssoLocation, ""))
+	c.SetSSOServer(settings["sso_server"])
+	assert.True(t, c.isSSOLocation("https://login.example.com/login?url=http://nventory", ssoLocation, ""))
+	assert.True(t, c.isSSOLocation("https://login.example.com/session/tokens/1", ssoTokenLocation, "/session/tokens"))
+	assert.False(t, c.isSSOLocation("https://sso.example.com/login?url=http://nventory", ssoLocation, ""))
+
+	defer func() { cookieFile = "" }()
+	cookieFile = settings["cookiefile"]
+	assert.Equal(t, "/home/me/.nventory_cookie", getCookieFilename("me"))
+	assert.NotEqual(t, "/home/me/.nventory_cookie", getCookieFilename(autoreg))
+}
+
+func TestProfilesInNventory(t *testing.T) {
+	settings := map[string]string{
+		"server":                    "http://nventory.example.com",
+		"profiles.staging.server":   "http://nventory-staging.example.com",
+		"profiles.staging.username": "jdoe",
+		"profiles.dc1.server":       "http://nventory-dc1.example.com",
+		"profiles.dc1.ca_file":      "/etc/nventory/dc1.pem",
+		"profiles.incomplete":       "not a profile setting",
+		"other.staging.server":      "not a profile either",
+	}
+	assert.Equal(t, []string{"dc1", "staging"}, ProfileNames(settings))
+
+	profile, err := ProfileSettings(settings, "Staging")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"server": "http://nventory-staging.example.com", "username": "jdoe"}, profile)
+	_, err = ProfileSettings(settings, "prod")
+	assert.Equal(t, ErrUsage, ErrorKind(err))
+
+	dir, err := ioutil.TempDir("", "nvprofile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, ".nventory.conf")
+	assert.Nil(t, SetConfigFileValue(filename, "profile", "staging"))
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("# comment\nserver = http://nventory\nprofile = staging\n"), 0600))
+	assert.Nil(t, os.Chmod(filename, 0600))
+	assert.Nil(t, SetConfigFileValue(filename, "profile", "dc1"))
+	assert.Nil(t, SetConfigFileValue(filename, "ca_file", "/etc/nventory/ca.pem"))
+	b, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "# comment\nserver = http://nventory\nprofile = dc1\nca_file = /etc/nventory/ca.pem\n", string(b))
+	fi, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// each profile has its own cookies
+	defer func() { cookieFile, cookieProfile = "", "" }()
+	cookieProfile = "dc1"
+	assert.Equal(t, filepath.Join(os.Getenv("HOME"), ".opsdb_cookie_dc1"), getCookieFilename("me"))
+	assert.Equal(t, filepath.Join(os.Getenv("HOME"), ".opsdb_cookie_autoreg_dc1"), getCookieFilename(autoreg))
+	cookieFile = "/home/me/.nventory_cookie"
+	assert.Equal(t, "/home/me/.nventory_cookie_dc1", getCookieFilename("me"))
+}
+
+func TestSessionInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// the session cookie holds the login of the account, mallory has none
+	accounts := map[string]string{"jdoe": "1", "alice": "2", "bob": "3"}
+	loggedOut := 0
+	tp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login := ""
+		if c, err := r.Cookie("session"); err == nil {
+			login = c.Value
+		}
+		switch {
+		case r.URL.Path == "/login/logout":
+			loggedOut++
+		case r.URL.Path == "/accounts.xml" && r.Header.Get("Authorization") == "Basic "+base64.StdEncoding.EncodeToString([]byte("bob:secret")):
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "bob"})
+		case r.URL.Path == "/accounts.xml" && login == "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/dashboard" && accounts[login] != "":
+			fmt.Fprintf(w, `<p id="account_links">Welcome back, <a href="/accounts/%v">%v</a>! | <a href="http://help">Help</a></p>`, accounts[login], strings.ToUpper(login))
+		case r.URL.Path == "/dashboard":
+			fmt.Fprint(w, `<p id="account_links"> | <a href="/login/login">Login</a></p>`)
+		case strings.HasPrefix(r.URL.Path, "/accounts/"):
+			for l, id := range accounts {
+				if r.URL.Path == "/accounts/"+id+".xml" {
+					fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><account><id type="integer">%v</id><login>%v</login><name>%v</name></account>`, id, l, strings.ToUpper(l))
+				}
+			}
+		}
+	}))
+	defer tp.Close()
+
+	home, err := ioutil.TempDir("", "nvsession")
+	assert.Nil(t, err)
+	defer os.RemoveAll(home)
+	t.Setenv("HOME", home)
+	cookieFile := filepath.Join(home, ".opsdb_cookie")
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(tp.URL)
+	driver.SetUsername("jdoe")
+
+	session, err := driver.Whoami()
+	assert.Nil(t, err)
+	assert.False(t, session.LoggedIn)
+	assert.Equal(t, "Not logged in to "+tp.URL+" as jdoe.\n", session.String())
+
+	host := strings.TrimPrefix(tp.URL, "http://")
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Nil(t, newCookieJar(cookieFile, tp.URL).Save([]*http.Cookie{
+		{Name: "session", Value: "jdoe", Domain: host, Expires: expires.Add(time.Hour)},
+		{Name: "token", Value: "2", Domain: "sso.example.com", Expires: expires},
+		{Name: "other", Value: "3", Domain: host},
+	}, host))
+
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.Equal(t, &Session{Login: "jdoe", Server: tp.URL, SSOHost: "sso.example.com", LoggedIn: true, Expires: expires, CookieFile: cookieFile}, session)
+
+	// a valid session is kept, without prompting
+	session, err = driver.Login()
+	assert.Nil(t, err)
+	assert.True(t, session.LoggedIn)
+
+	assert.Nil(t, os.Remove(cookieFile))
+	assert.Nil(t, newCookieJar(cookieFile, tp.URL).Save([]*http.Cookie{{Name: "session", Value: "jdoe"}}, host))
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.Equal(t, "", session.SSOHost)
+	assert.True(t, session.Expires.IsZero())
+
+	assert.Nil(t, driver.Logout())
+	assert.Equal(t, 1, loggedOut)
+	_, err = os.Stat(cookieFile)
+	assert.True(t, os.IsNotExist(err))
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.False(t, session.LoggedIn)
+
+	// nothing to end, and no cookie file to remove
+	assert.Nil(t, driver.Logout())
+	assert.Equal(t, 1, loggedOut)
+
+	// the cookie file has the session of alice, not of --username bob
+	assert.Nil(t, newCookieJar(cookieFile, tp.URL).Save([]*http.Cookie{{Name: "session", Value: "alice"}}, host))
+	driver.SetUsername("bob")
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.True(t, session.LoggedIn)
+	assert.Equal(t, "alice", session.Login)
+	assert.Contains(t, session.String(), "as alice.")
+
+	// logging in as bob replaces it
+	t.Setenv(passwordEnv, "secret")
+	assert.Nil(t, driver.SetAuthMethod(AuthBasic))
+	session, err = driver.Login()
+	assert.Nil(t, err)
+	assert.True(t, session.LoggedIn)
+	assert.Equal(t, "bob", session.Login)
+	cookies, err := newCookieJar(cookieFile, tp.URL).Cookies()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cookies))
+	assert.Equal(t, "bob", cookies[0].Value)
+
+	// a session the server has no account for
+	assert.Nil(t, newCookieJar(cookieFile, tp.URL).Save([]*http.Cookie{{Name: "session", Value: "mallory"}}, host))
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.False(t, session.LoggedIn)
+	assert.Equal(t, "bob", session.Login)
+
+	assert.Nil(t, os.Remove(cookieFile))
+
+	// an SSO session is ended at sso_logout_url, with the token cookie
+	ssoLogouts := make([]string, 0)
+	sso := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if c, err := r.Cookie("token"); err == nil {
+			token = c.Value
+		}
+		ssoLogouts = append(ssoLogouts, r.Method+" "+r.URL.Path+" "+token)
+	}))
+	defer sso.Close()
+	nvURL := strings.Replace(tp.URL, "127.0.0.1", "localhost", 1)
+	driver = NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(nvURL)
+	driver.SetUsername("jdoe")
+	driver.SetSSOServer(sso.URL)
+	assert.Nil(t, driver.SetTLSOptions(TLSOptions{Insecure: true}))
+	ssoHost := strings.TrimPrefix(sso.URL, "https://")
+	ssoSession := []*http.Cookie{
+		{Name: "session", Value: "jdoe", Domain: "localhost"},
+		{Name: "token", Value: "t0ken", Domain: ssoHost},
+	}
+
+	assert.Nil(t, newCookieJar(cookieFile, nvURL).Save(ssoSession, "localhost"))
+	session, err = driver.Whoami()
+	assert.Nil(t, err)
+	assert.True(t, session.LoggedIn)
+	assert.Equal(t, "127.0.0.1", session.SSOHost)
+	assert.Nil(t, driver.Logout())
+	assert.Equal(t, []string{}, ssoLogouts)
+	_, err = os.Stat(cookieFile)
+	assert.True(t, os.IsNotExist(err))
+
+	driver.SetSSOLogoutURL(sso.URL + "/sessions/destroy")
+	assert.Nil(t, newCookieJar(cookieFile, nvURL).Save(ssoSession, "localhost"))
+	assert.Nil(t, driver.Logout())
+	assert.Equal(t, []string{"GET /sessions/destroy t0ken"}, ssoLogouts)
+	assert.Equal(t, 1, loggedOut)
+	_, err = os.Stat(cookieFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCookieJarInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "nvcookies")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, ".opsdb_cookie")
+
+	names := func(cookies []*http.Cookie, err error) []string {
+		assert.Nil(t, err)
+		result := make([]string, 0)
+		for _, c := range cookies {
+			result = append(result, c.Name+"="+c.Value+"@"+c.Domain)
+		}
+		return result
+	}
+
+	// lines written by older versions, without a server and with a port
+	legacy := `{"Name":"old","Value":"1","Path":"/","Domain":"nv.example.com:443","Expires":"0001-01-01T00:00:00Z","MaxAge":0}` + "\n" +
+		`{"Name":"gone","Value":"1","Path":"/","Domain":"nv.example.com","Expires":"2001-01-01T00:00:00Z","MaxAge":0}` + "\n" +
+		"not json\n"
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(legacy), 0644))
+
+	nv := newCookieJar(filename, "https://nv.example.com:443")
+	other := newCookieJar(filename, "nv2.example.com")
+	assert.Equal(t, []string{"old=1@nv.example.com"}, names(nv.Cookies()))
+	assert.Equal(t, []string{"old=1@nv.example.com"}, names(other.Cookies()))
+
+	assert.Nil(t, nv.Save([]*http.Cookie{
+		{Name: "old", Value: "2"},
+		{Name: "token", Value: "a", Domain: ".SSO.example.com", MaxAge: 3600},
+	}, "nv.example.com"))
+	assert.Nil(t, other.Save([]*http.Cookie{{Name: "token", Value: "b", Domain: "sso.example.com"}}, "nv2.example.com"))
+	assert.Equal(t, []string{"old=2@nv.example.com", "token=a@sso.example.com"}, names(nv.Cookies()))
+	assert.Equal(t, []string{"token=b@sso.example.com"}, names(other.Cookies()))
+
+	cookies, _ := nv.Cookies()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cookies[1].Expires, time.Minute)
+	fi, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// a changed value replaces the cookie, an expired one removes it
+	assert.Nil(t, nv.Save([]*http.Cookie{
+		{Name: "token", Value: "c", Domain: "sso.example.com"},
+		{Name: "old", Value: "", MaxAge: -1},
+	}, "nv.example.com"))
+	assert.Equal(t, []string{"token=c@sso.example.com"}, names(nv.Cookies()))
+	assert.Equal(t, []string{"token=b@sso.example.com"}, names(other.Cookies()))
+
+	// concurrent runs don't lose each other's cookies
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, nv.Save([]*http.Cookie{{Name: fmt.Sprintf("c%v", i), Value: "1"}}, "nv.example.com"))
+		}(i)
+	}
+	wg.Wait()
+	cookies, err = nv.Cookies()
+	assert.Nil(t, err)
+	assert.Len(t, cookies, 21)
+
+	// the cookies are sent back to their domain, over https if secure
+	assert.Nil(t, nv.Save([]*http.Cookie{{Name: "secure", Value: "1", Secure: true}}, "nv.example.com"))
+	client := NewHttpClient().createBlankHttpClient()
+	nv.LoadInto(client)
+	u, _ := url.Parse("https://nv.example.com/accounts.xml")
+	assert.Len(t, client.Jar.Cookies(u), 21)
+	u, _ = url.Parse("http://nv.example.com/accounts.xml")
+	assert.Len(t, client.Jar.Cookies(u), 20)
+
+	assert.Nil(t, nv.Clear())
+	assert.Equal(t, []string{"token=b@sso.example.com"}, names(other.Cookies()))
+	assert.Nil(t, other.Clear())
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRubyCookieFormatInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "nvcookies")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	t.Setenv("HOME", dir)
+	filename := filepath.Join(dir, ".nventory_cookie")
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	assert.Equal(t, ErrUsage, ErrorKind(driver.SetCookieFormat("yaml")))
+	assert.Nil(t, driver.SetCookieFormat(CookieFormatRuby))
+	defer driver.SetCookieFormat("")
+	assert.Equal(t, filename, getCookieFilename("me"))
+	// the ruby client keeps the autoreg session in root's home, whatever HOME is
+	assert.Equal(t, "/root/.nventory_cookie_autoreg", getCookieFilename(autoreg))
+
+	// as written by the ruby client, the perl one and an older go client
+	content := "Set-Cookie: _session=abc; path=/; HttpOnly; domain=nv.example.com\n" +
+		"Set-Cookie: token=t1=x; expires=Fri, 31-Dec-2049 23:59:59 GMT; path=/; domain=.sso.example.com; secure; path=/other\n" +
+		"Set-Cookie3: perl=1; path=\"/\"; domain=nv.example.com; path_spec; expires=\"2049-12-31 23:59:59Z\"; version=0\n" +
+		"Set-Cookie: old=1; expires=Fri, 31-Dec-2010 23:59:59 GMT; path=/; domain=nv.example.com\n" +
+		"# comment\n" +
+		"\n" +
+		`{"Name":"json","Value":"1","Path":"/","Domain":"nv.example.com","Expires":"0001-01-01T00:00:00Z","MaxAge":0}` + "\n"
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(content), 0600))
+
+	jar := newCookieJar(filename, "https://nv.example.com")
+	assert.Equal(t, CookieFormatRuby, jar.format)
+	cookies, err := jar.Cookies()
+	assert.Nil(t, err)
+	assert.Equal(t, []*http.Cookie{
+		{Name: "_session", Value: "abc", Path: "/", Domain: "nv.example.com", HttpOnly: true},
+		{Name: "token", Value: "t1=x", Path: "/", Domain: "sso.example.com", Expires: time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC), Secure: true},
+		{Name: "perl", Value: "1", Path: "/", Domain: "nv.example.com", Expires: time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{Name: "json", Value: "1", Path: "/", Domain: "nv.example.com"},
+	}, normalizeCookieTimes(cookies))
+
+	assert.Nil(t, jar.Save([]*http.Cookie{{Name: "_session", Value: "def"}}, "nv.example.com"))
+	b, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "Set-Cookie: token=t1=x; path=/; domain=sso.example.com; expires=Fri, 31-Dec-2049 23:59:59 GMT; secure\n"+
+		"Set-Cookie: perl=1; path=/; domain=nv.example.com; expires=Fri, 31-Dec-2049 23:59:59 GMT\n"+
+		"Set-Cookie: json=1; path=/; domain=nv.example.com\n"+
+		"Set-Cookie: _session=def; path=/; domain=nv.example.com\n", string(b))
+
+	// the json format is read back the same
+	jar.format = CookieFormatJSON
+	assert.Nil(t, jar.Save([]*http.Cookie{{Name: "json", Value: "2"}}, "nv.example.com"))
+	jsonCookies, err := jar.Cookies()
+	assert.Nil(t, err)
+	assert.Len(t, jsonCookies, 4)
+	assert.Equal(t, "token", jsonCookies[0].Name)
+	assert.True(t, jsonCookies[0].Secure)
+}
+
+// normalizeCookieTimes returns cookies with their expiry in UTC, to compare
+// them.
+func normalizeCookieTimes(cookies []*http.Cookie) []*http.Cookie {
+	for _, c := range cookies {
+		if !c.Expires.IsZero() {
+			c.Expires = c.Expires.UTC()
+		}
+	}
+	return cookies
+}
+
+func TestCredentialsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "nvcredentials")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv(passwordEnv, "")
+
+	netrc := filepath.Join(dir, ".netrc")
+	assert.Nil(t, ioutil.WriteFile(netrc, []byte("machine sso.example.com login jdoe password sso-pw\n"+
+		"machine nv.example.com\n  login jdoe\n  password nv-pw\n"+
+		"macdef init\n  machine nv.example.com password macro-pw\n\n"+
+		"machine other.example.com login someone password other-pw\n"+
+		"default password default-pw\n"), 0600))
+	password := func(c *credentials, hosts ...string) string {
+		p, err := c.password("jdoe", hosts...)
+		assert.Nil(t, err)
+		return p
+	}
+
+	c := &credentials{}
+	assert.Equal(t, "sso-pw", password(c, "sso.example.com:443", "nv.example.com"))
+	assert.Equal(t, "nv-pw", password(c, "unknown.example.com", "nv.example.com"))
+	assert.Equal(t, "default-pw", password(c, "other.example.com"))
+	assert.Equal(t, "default-pw", password(&credentials{CredentialOptions: CredentialOptions{NetrcFile: netrc}}))
+
+	c = &credentials{CredentialOptions: CredentialOptions{PasswordStdin: true}, input: bufio.NewReader(strings.NewReader("stdin-pw\r\nnext line\n"))}
+	assert.Equal(t, "stdin-pw", password(c, "nv.example.com"))
+	assert.Equal(t, "stdin-pw", password(c, "nv.example.com"))
+	t.Setenv(passwordEnv, "env-pw")
+	assert.Equal(t, "env-pw", password(c, "nv.example.com"))
+
+	passwordFile := filepath.Join(dir, "password")
+	assert.Nil(t, ioutil.WriteFile(passwordFile, []byte("file-pw\nignored\n"), 0600))
+	c.PasswordFile = passwordFile
+	assert.Equal(t, "file-pw", password(c, "nv.example.com"))
+	c.PasswordFile = filepath.Join(dir, "missing")
+	_, err = c.password("jdoe")
+	assert.Equal(t, ErrUsage, ErrorKind(err))
+
+	// log in through the SSO server without a terminal, and without logging
+	// the password or the session
+	var logs bytes.Buffer
+	logger.InitLogger(&logs, &logs, &logs, &logs, &logs)
+	defer logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	sso := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login" || r.FormValue("login") != "jdoe" || r.FormValue("password") != "s3cret-pw" {
+			w.WriteHeader(401)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sso_token", Value: "t0ken-value", Path: "/"})
+		w.WriteHeader(200)
+	}))
+	defer sso.Close()
+	nv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sso_token")
+		switch {
+		case err != nil || c.Value != "t0ken-value":
+			http.Redirect(w, r, sso.URL+"/login?url=http://nventory", http.StatusFound)
+		case r.URL.Path == "/dashboard":
+			w.Write([]byte(`<p id="account_links">Welcome back, <a href="/accounts/1">J Doe</a>!</p>`))
+		case r.URL.Path == "/accounts/1.xml":
+			w.Write([]byte("<account><id>1</id><login>jdoe</login></account>"))
+		default:
+			w.Write([]byte("<accounts><account><password_hash>h4sh</password_hash></account></accounts>"))
+		}
+	}))
+	defer nv.Close()
+
+	driver := NewNventoryDriver(bufio.NewReader(strings.NewReader("")))
+	driver.SetServer(nv.URL)
+	driver.SetSSOServer(sso.URL)
+	driver.SetUsername("jdoe")
+	assert.Nil(t, driver.SetTLSOptions(TLSOptions{Insecure: true}))
+
+	t.Setenv(passwordEnv, "wrong-pw")
+	_, loginErr := driver.Login()
+	assert.Equal(t, ErrAuth, ErrorKind(loginErr))
+
+	t.Setenv(passwordEnv, "s3cret-pw")
+	session, err := driver.Login()
+	assert.Nil(t, err)
+	assert.True(t, session.LoggedIn)
+	assert.Contains(t, logs.String(), "Using the password of jdoe from NV_PASSWORD")
+	for _, secret := range []string{"s3cret-pw", "wrong-pw", "t0ken-value", "h4sh"} {
+		assert.NotContains(t, logs.String(), secret)
+		assert.NotContains(t, loginErr.Error()+session.String(), secret)
+	}
+}
+
+func TestAuthenticatorsInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "nvauth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv(passwordEnv, "s3cret")
+
+	c := NewHttpClient()
+	assert.Equal(t, ErrUsage, ErrorKind(c.SetAuthMethod("kerberos")))
+	assert.IsType(t, &ssoAuthenticator{}, c.authenticatorFor("jdoe"))
+	assert.IsType(t, &localAuthenticator{}, c.authenticatorFor(autoreg))
+	assert.Nil(t, c.SetAuthMethod(AuthBasic))
+	assert.IsType(t, &headerAuthenticator{}, c.authenticatorFor(autoreg))
+
+	redirect := func(location string) *http.Response {
+		return &http.Response{StatusCode: 302, Header: http.Header{"Location": []string{location}}}
+	}
+	sso := &ssoAuthenticator{c: NewHttpClient()}
+	assert.True(t, sso.LoginRequired(redirect("https://sso.example.com/login?url=http://nventory")))
+	assert.False(t, sso.LoginRequired(redirect("https://login.example.com/login?url=http://nventory")))
+	assert.False(t, sso.LoginRequired(redirect("https://nventory/accounts.xml")))
+	sso.c.SetSSOServer("https://login.example.com")
+	assert.True(t, sso.LoginRequired(redirect("https://login.example.com/login?url=http://nventory")))
+	local := &localAuthenticator{c: NewHttpClient()}
+	assert.True(t, local.LoginRequired(redirect("https://sso.example.com/login?url=http://nventory")))
+	assert.True(t, local.LoginRequired(redirect("/login/login")))
+	assert.False(t, local.LoginRequired(redirect("https://nventory/accounts.xml")))
+	assert.False(t, local.LoginRequired(&http.Response{StatusCode: 200}))
+
+	// a server logging in with a form, a header or a session cookie
+	var logins []string
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, basic := r.BasicAuth()
+		switch {
+		case r.URL.Path == "/login/login":
+			logins = append(logins, r.FormValue("login")+":"+r.FormValue("password"))
+			// like LoginController, back to the login page when the
+			// password is wrong
+			if r.FormValue("password") != "s3cret" && r.FormValue("password") != autoreg_password {
+				http.Redirect(w, r, "/login/login", http.StatusFound)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "_session", Value: r.FormValue("login")})
+			http.Redirect(w, r, "/dashboard", http.StatusFound)
+		case basic:
+			logins = append(logins, "basic "+user+":"+password)
+			if password != "s3cret" {
+				w.WriteHeader(401)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "_session", Value: user})
+			w.WriteHeader(200)
+		case r.Header.Get("Authorization") != "":
+			logins = append(logins, r.Header.Get("Authorization"))
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				w.WriteHeader(401)
+				return
+			}
+			w.WriteHeader(200)
+		case strings.HasPrefix(r.URL.Path, "/loop"):
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+		default:
+			if _, err := r.Cookie("_session"); err == nil {
+				w.WriteHeader(200)
+			} else if strings.HasPrefix(r.URL.Path, "/header") {
+				w.WriteHeader(401)
+			} else {
+				http.Redirect(w, r, server.URL+"/login?url=http://nventory", http.StatusFound)
+			}
+		}
+	}))
+	defer server.Close()
+
+	login := func(method string, username string) error {
+		client := NewNventoryClient(username, bufio.NewReader(strings.NewReader("")))
+		client.SetServer(server.URL)
+		assert.Nil(t, client.SetTLSOptions(TLSOptions{Insecure: true}))
+		assert.Nil(t, client.SetAuthMethod(method))
+		if method == AuthBasic || method == AuthBearer {
+			client.SetServer(server.URL + "/header")
+		}
+		_, err := client.GetHttpClientFor(username)
+		return err
+	}
+
+	assert.Nil(t, login(AuthSSO, autoreg))
+	assert.Nil(t, login(AuthLocal, "jdoe"))
+	// the session is kept in the cookie file
+	assert.Nil(t, login(AuthLocal, "jdoe"))
+	assert.Equal(t, []string{autoreg + ":" + autoreg_password, "jdoe:s3cret"}, logins)
+
+	logins = nil
+	assert.Nil(t, os.Remove(filepath.Join(dir, ".opsdb_cookie")))
+	assert.Nil(t, login(AuthBearer, "jdoe"))
+	assert.Nil(t, login(AuthBasic, "jdoe"))
+	assert.Nil(t, os.Remove(filepath.Join(dir, ".opsdb_cookie")))
+	t.Setenv(passwordEnv, "wrong")
+	assert.Equal(t, ErrAuth, ErrorKind(login(AuthBearer, "jdoe")))
+	assert.Equal(t, ErrAuth, ErrorKind(login(AuthBasic, "jdoe")))
+	assert.Equal(t, ErrAuth, ErrorKind(login(AuthLocal, "jdoe")))
+	assert.Equal(t, []string{"Bearer s3cret", "basic jdoe:s3cret", "Bearer wrong", "basic jdoe:wrong", "jdoe:wrong"}, logins)
+	_, err = os.Stat(filepath.Join(dir, ".opsdb_cookie"))
+	assert.True(t, os.IsNotExist(err), "no session is saved for a wrong password")
+
+	// the header isn't sent to other hosts
+	var sent []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	transport := &authTransport{base: http.DefaultTransport, host: "nventory.invalid", authorization: "Bearer s3cret"}
+	resp, err := (&http.Client{Transport: transport}).Get(other.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{""}, sent)
+
+	// redirects that don't ask to log in end
+	client := NewNventoryClient("jdoe", bufio.NewReader(strings.NewReader("")))
+	client.SetServer(server.URL + "/loop")
+	assert.Nil(t, client.SetTLSOptions(TLSOptions{Insecure: true}))
+	_, err = client.GetHttpClientFor("jdoe")
+	assert.Equal(t, ErrServer, ErrorKind(err))
+
+	// any authenticator can be plugged in
+	custom := &fakeAuthenticator{}
+	client = NewNventoryClient("jdoe", bufio.NewReader(strings.NewReader("")))
+	client.SetServer(server.URL)
+	assert.Nil(t, client.SetTLSOptions(TLSOptions{Insecure: true}))
+	client.HttpClient.SetAuthenticator(custom)
+	_, err = client.GetHttpClientFor("jdoe")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"jdoe"}, custom.logins)
+}
+
+func TestSSOLoginInNventory(t *testing.T) {
+	logger.InitLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "nvsso")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	t.Setenv("HOME", dir)
+
+	// an SSO server that isn't named sso*, taking the form twice before
+	// redirecting to the session token of the nventory server
+	var ssoRequests []string
+	var sso *httptest.Server
+	var nvURL string
+	sso = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ssoRequests = append(ssoRequests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/login" && r.FormValue("login") == "looper":
+			http.Redirect(w, r, sso.URL+"/login/next", http.StatusFound)
+		case r.URL.Path == "/login" && r.FormValue("password") != "s3cret":
+			w.WriteHeader(401)
+		case r.URL.Path == "/login":
+			if _, err := r.Cookie("sso_step"); err != nil {
+				http.SetCookie(w, &http.Cookie{Name: "sso_step", Value: "1", Path: "/"})
+				http.Redirect(w, r, sso.URL+"/login/next", http.StatusFound)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "sso_session", Value: r.FormValue("login"), Path: "/"})
+			http.Redirect(w, r, sso.URL+"/session/tokens?url="+url.QueryEscape(nvURL+"/accounts.xml"), http.StatusFound)
+		case r.URL.Path == "/session/tokens":
+			if c, err := r.Cookie("sso_session"); err == nil && c.Value == "jdoe" {
+				http.Redirect(w, r, r.FormValue("url")+"?token=t0ken", http.StatusFound)
+				return
+			}
+			w.Write([]byte("<html>login page</html>"))
+		}
+	}))
+	defer sso.Close()
+	nv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("nv_session"); err == nil && c.Value == "t0ken" {
+			w.Write([]byte("<accounts></accounts>"))
+		} else if r.FormValue("token") == "t0ken" {
+			http.SetCookie(w, &http.Cookie{Name: "nv_session", Value: "t0ken", Path: "/"})
+			http.Redirect(w, r, "/accounts.xml", http.StatusFound)
+		} else {
+			http.Redirect(w, r, sso.URL+"/login?url="+url.QueryEscape(nvURL+"/accounts.xml"), http.StatusFound)
+		}
+	}))
+	defer nv.Close()
+	// keep the cookies of the two servers apart
+	nvURL = strings.Replace(nv.URL, "127.0.0.1", "localhost", 1)
+
+	login := func(username, password string) (*http.Client, error) {
+		os.Remove(filepath.Join(dir, ".opsdb_cookie"))
+		ssoRequests = nil
+		t.Setenv(passwordEnv, password)
+		client := NewNventoryClient(username, bufio.NewReader(strings.NewReader("")))
+		client.SetServer(nvURL)
+		client.SetSSOServer(sso.URL)
+		assert.Nil(t, client.SetTLSOptions(TLSOptions{Insecure: true}))
+		return client.GetHttpClientFor(username)
+	}
+
+	httpClient, err := login("jdoe", "s3cret")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"POST /login", "POST /login", "GET /session/tokens"}, ssoRequests)
+	if assert.NotNil(t, httpClient) {
+		resp, err := httpClient.Get(nvURL + "/accounts.xml")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	_, err = login("jdoe", "wrong")
+	assert.Equal(t, ErrAuth, ErrorKind(err))
+	assert.Equal(t, []string{"POST /login"}, ssoRequests)
+
+	_, err = login("looper", "s3cret")
+	assert.Equal(t, ErrAuth, ErrorKind(err))
+	assert.Contains(t, err.Error(), "SSO redirect loop")
+	assert.Equal(t, maxLoginRedirects, len(ssoRequests))
+
+	// the SSO server has no session to give the token of
+	_, err = login("mallory", "s3cret")
+	assert.Equal(t, ErrAuth, ErrorKind(err))
+	assert.Equal(t, []string{"POST /login", "POST /login", "GET /session/tokens"}, ssoRequests)
+}
+
+// fakeAuthenticator takes any redirect for a login page, and logs in without
+// asking the server.
+type fakeAuthenticator struct {
+	logins []string
+}
+
+func (a *fakeAuthenticator) LoginRequired(resp *http.Response) bool {
+	return isRedirectResponse(resp)
+}
+
+func (a *fakeAuthenticator) Login(ctx context.Context, client *http.Client, username string, resp *http.Response) error {
+	a.logins = append(a.logins, username)
+	return nil
+}
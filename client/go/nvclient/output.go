@@ -0,0 +1,215 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+	OutputCSV  = "csv"
+	OutputTSV  = "tsv"
+)
+
+var OutputFormats = []string{OutputText, OutputJSON, OutputYAML, OutputCSV, OutputTSV}
+
+// FormatResults renders r in the given --output format.
+//
+//	fields:	columns for csv/tsv (all fields if empty), filter for text
+func FormatResults(r Result, fields []string, output string) (string, error) {
+	switch output {
+	case "", OutputText:
+		return PrintResultsFilterByFields(r, fields), nil
+	case OutputJSON:
+		b, err := json.MarshalIndent(toOrdered(r), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case OutputYAML:
+		b, err := yaml.Marshal(toOrdered(r))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case OutputCSV:
+		return printResultsDelimited(r, fields, ',')
+	case OutputTSV:
+		return printResultsDelimited(r, fields, '\t')
+	}
+	return "", newError(ErrUsage, "Unknown output format %v, expected one of: %v\n", output, strings.Join(OutputFormats, ", "))
+}
+
+/*******
+ * orderedMap keeps ResultMap key order when serialized to json or yaml.
+ *******/
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		val, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func (m *orderedMap) MarshalYAML() (interface{}, error) {
+	ms := make(yaml.MapSlice, 0, len(m.keys))
+	for _, k := range m.keys {
+		ms = append(ms, yaml.MapItem{Key: k, Value: m.values[k]})
+	}
+	return ms, nil
+}
+
+// toOrdered converts a Result tree to values json and yaml can marshal.
+func toOrdered(r Result) interface{} {
+	switch t := r.(type) {
+	case *ResultArray:
+		arr := make([]interface{}, 0, len(t.Array))
+		for _, elm := range t.Array {
+			arr = append(arr, toOrdered(elm))
+		}
+		return arr
+	case *ResultMap:
+		m := &orderedMap{keys: t.GetOrder(), values: make(map[string]interface{}, len(t.Map))}
+		for _, k := range t.GetOrder() {
+			m.values[k] = toOrdered(t.Get(k))
+		}
+		return m
+	case *ResultValue:
+		return t.Value
+	}
+	return nil
+}
+
+/*******
+ * flatRow holds one object flattened to field[subfield] keys, e.g.
+ * hardware_profile[name]. Values from nested arrays are collected in order.
+ *******/
+type flatRow struct {
+	order  []string
+	values map[string][]string
+}
+
+func newFlatRow() *flatRow {
+	return &flatRow{order: make([]string, 0), values: make(map[string][]string, 0)}
+}
+
+func (row *flatRow) add(key, value string) {
+	if _, ok := row.values[key]; !ok {
+		row.order = append(row.order, key)
+	}
+	row.values[key] = append(row.values[key], value)
+}
+
+func (row *flatRow) get(key string) string {
+	return strings.Join(row.values[key], ",")
+}
+
+func flattenResult(r Result, parent string, row *flatRow) {
+	switch t := r.(type) {
+	case *ResultArray:
+		for _, elm := range t.Array {
+			flattenResult(elm, parent, row)
+		}
+	case *ResultMap:
+		for _, k := range t.GetOrder() {
+			flattenResult(t.Get(k), combineName(parent, k), row)
+		}
+	case *ResultValue:
+		row.add(parent, t.Value)
+	}
+}
+
+func printResultsDelimited(r Result, fields []string, delimiter rune) (string, error) {
+	rows := make([]*flatRow, 0)
+	switch t := r.(type) {
+	case *ResultArray:
+		for _, elm := range t.Array {
+			row := newFlatRow()
+			flattenResult(elm, "", row)
+			rows = append(rows, row)
+		}
+	case *ResultMap:
+		row := newFlatRow()
+		flattenResult(t, "", row)
+		rows = append(rows, row)
+	}
+
+	columns := make([]string, 0)
+	seen := make(map[string]bool, 0)
+	addColumn := func(c string) {
+		if !seen[c] {
+			seen[c] = true
+			columns = append(columns, c)
+		}
+	}
+	if len(fields) == 0 {
+		for _, row := range rows {
+			for _, k := range row.order {
+				addColumn(k)
+			}
+		}
+	} else {
+		addColumn("name")
+		for _, f := range fields {
+			addColumn(search_shortcuts.Replace(f))
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = delimiter
+	if err := w.Write(columns); err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		record := make([]string, 0, len(columns))
+		for _, c := range columns {
+			record = append(record, row.get(c))
+		}
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
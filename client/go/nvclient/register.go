@@ -0,0 +1,121 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// Register creates or updates the node described by data (as gathered by
+// HostInfo) through the autoreg account. Like the ruby client, an existing
+// node is looked up by its uniqueid first, so a renamed host updates its old
+// entry, then by its name and finally by its serial number.
+func (f *NventoryClient) Register(data map[string]string) (string, error) {
+	if data["name"] == "" {
+		return "", newError(ErrUsage, "Can't register a node without a name.\n")
+	}
+
+	nodes, err := f.findRegisteredNode(data)
+	if err != nil {
+		return "Unable to get nodes.", err
+	}
+
+	values := registerValues(data)
+	plan := make([]mutation, 0, 1)
+	if len(nodes) == 0 {
+		plan = append(plan, mutation{name: data["name"], method: "POST", url: f.getCreateUrl("nodes", values.Encode()), values: values})
+	}
+	for _, node := range nodes {
+		id := getResultValue(node, "id")
+		plan = append(plan, mutation{id: id, name: getResultName(node), method: "PUT", url: f.getSetUrl("nodes", id, values.Encode()), values: values})
+	}
+
+	msg, err := f.applyMutations(plan, "", autoreg, true, "registration")
+	return registerSummary(data) + msg, err
+}
+
+// registerValues converts data to form values. Like the ruby client sends
+// them, the fields of other models (hardware_profile[model]) are sent as they
+// are and the others are nested under node (name => node[name]).
+func registerValues(data map[string]string) url.Values {
+	values := url.Values{}
+	for k, v := range data {
+		if strings.Contains(k, "[") {
+			values.Set(k, v)
+		} else {
+			values.Set(singularize("nodes")+"["+k+"]", v)
+		}
+	}
+	return values
+}
+
+// findRegisteredNode returns the nodes matching the uniqueid, name or serial
+// number in data, in that order of preference.
+func (f *NventoryClient) findRegisteredNode(data map[string]string) ([]*ResultMap, error) {
+	lookups := make([]string, 0)
+	if uniqueid := data["uniqueid"]; uniqueid != "" {
+		lookups = append(lookups, "uniqueid="+uniqueid)
+		// dmidecode before 2.10 displayed the first three fields of the UUID
+		// byte-reversed, so a node registered with an older one still has
+		// the reversed uniqueid.
+		if reversed := reverseUniqueID(uniqueid); reversed != uniqueid {
+			lookups = append(lookups, "uniqueid="+reversed)
+		}
+	}
+	lookups = append(lookups, "name="+data["name"])
+	if serial := data["serial_number"]; serial != "" && serial != "Not Specified" {
+		lookups = append(lookups, "serial_number="+serial)
+	}
+
+	for _, lookup := range lookups {
+		logger.Debug.Printf("Looking for registered node with %v\n", lookup)
+		nodes, err := f.getResultMaps("nodes", Conditions{"exact_": []string{lookup}}, []string{})
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) > 0 {
+			return nodes, nil
+		}
+	}
+	return []*ResultMap{}, nil
+}
+
+// reverseUniqueID reverses the bytes of the first three fields of a UUID,
+// e.g. 44454C4C-5300-1052-... becomes 4C4C4544-0053-5210-...
+func reverseUniqueID(uniqueid string) string {
+	fields := strings.SplitN(uniqueid, "-", 4)
+	if len(fields) != 4 {
+		return uniqueid
+	}
+	for i := 0; i < 3; i++ {
+		reversed := ""
+		for j := len(fields[i]); j >= 2; j -= 2 {
+			reversed += fields[i][j-2 : j]
+		}
+		if len(fields[i])%2 == 1 {
+			reversed += fields[i][:1]
+		}
+		fields[i] = reversed
+	}
+	return strings.Join(fields, "-")
+}
+
+func registerSummary(data map[string]string) string {
+	return fmt.Sprintf("Registering %v (uniqueid %v) as %v.\n", data["name"], data["uniqueid"], autoreg)
+}
@@ -15,7 +15,6 @@
 package nvclient
 
 import (
-	"log"
 	_ "strings"
 
 	"errors"
@@ -25,11 +24,16 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/kardianos/osext"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 
 
 var defaultServer = "http://nventory"
+var defaultTimeout time.Duration
+var defaultProxy string
+var defaultUsername string
 /******************************************************************************
 SetCommands:
 	Stores all commands and flags related to searching a value.
@@ -37,19 +41,30 @@ SetCommands:
 
 type SearchCommands struct {
 	searchFlags    *SearchFlags
-	nodeGroupNodes bool
-	nodeGroup      bool
-
-	debug        bool
-	dryRun       bool
-	yes          bool
-	register     bool
-	noSwitchport bool
-	noStorage    bool
-	allFields    bool
-	username     string
-	server       string
-	objectType   string
+	nodeGroupNodes []string
+	nodeGroup      string
+
+	debug         bool
+	dryRun        bool
+	yes           bool
+	register      bool
+	noSwitchport  bool
+	noStorage     bool
+	allFields     bool
+	fieldNames    bool
+	allValues     []string
+	countValues   bool
+	sortValues    string
+	username      string
+	passwordFile  string
+	passwordStdin bool
+	server        string
+	timeout       time.Duration
+	insecure      bool
+	proxy         string
+	objectType    string
+	output        string
+	format        string
 
 	withAliases   bool
 	showtags      bool
@@ -60,8 +75,10 @@ type SearchCommands struct {
 }
 
 func (c *SearchCommands) GetSearchFlags() *SearchFlags { return c.searchFlags }
-func (c *SearchCommands) IsNodeGroupNodes() bool       { return c.nodeGroupNodes }
-func (c *SearchCommands) IsNodeGroup() bool            { return c.nodeGroup }
+func (c *SearchCommands) IsNodeGroupNodes() bool       { return len(c.nodeGroupNodes) > 0 }
+func (c *SearchCommands) GetNodeGroupNodes() []string  { return c.nodeGroupNodes }
+func (c *SearchCommands) IsNodeGroup() bool            { return c.nodeGroup != "" }
+func (c *SearchCommands) GetNodeGroup() string         { return c.nodeGroup }
 func (c *SearchCommands) IsDebug() bool                { return c.debug }
 func (c *SearchCommands) IsDryRun() bool               { return c.dryRun }
 func (c *SearchCommands) IsYes() bool                  { return c.yes }
@@ -69,13 +86,27 @@ func (c *SearchCommands) IsRegister() bool             { return c.register }
 func (c *SearchCommands) IsNoSwitchport() bool         { return c.noSwitchport }
 func (c *SearchCommands) IsNoStorage() bool            { return c.noStorage }
 func (c *SearchCommands) IsAllFields() bool            { return c.allFields }
+func (c *SearchCommands) IsGetFieldNames() bool        { return c.fieldNames }
+func (c *SearchCommands) GetAllValues() []string       { return c.allValues }
+func (c *SearchCommands) IsCountValues() bool          { return c.countValues }
+func (c *SearchCommands) GetSortValues() string        { return c.sortValues }
 func (c *SearchCommands) GetUsername() string          { return c.username }
+func (c *SearchCommands) SetDefaultUsername(u string)  { defaultUsername = u }
+func (c *SearchCommands) GetPasswordFile() string      { return c.passwordFile }
+func (c *SearchCommands) IsPasswordStdin() bool        { return c.passwordStdin }
 func (c *SearchCommands) GetServer() string            { return c.server }
 func (c *SearchCommands) SetDefaultServer(s string)    { defaultServer = s }
+func (c *SearchCommands) GetTimeout() time.Duration    { return c.timeout }
+func (c *SearchCommands) SetDefaultTimeout(t time.Duration) { defaultTimeout = t }
+func (c *SearchCommands) IsInsecure() bool             { return c.insecure }
+func (c *SearchCommands) GetProxy() string             { return c.proxy }
+func (c *SearchCommands) SetDefaultProxy(p string)     { defaultProxy = p }
 func (c *SearchCommands) IsWithAliases() bool          { return c.withAliases }
 func (c *SearchCommands) IsShowTags() bool             { return c.showtags}
 func (c *SearchCommands) IsShowVersion() bool          { return c.showVersion}
 func (c *SearchCommands) GetVersion() string           { return c.version}
+func (c *SearchCommands) GetOutput() string            { return c.output }
+func (c *SearchCommands) GetFormat() string            { return c.format }
 
 func NewSearchCommand(searchFlags *SearchFlags, driver Driver) *SearchCommands {
 	sc := &SearchCommands{searchFlags: searchFlags, driver: driver}
@@ -90,6 +121,7 @@ func (sc *SearchCommands) GetFlagMap() map[string][]string {
 		flagMap["regex_"] = sc.GetSearchFlags().Regexget
 		flagMap["exclude_"] = sc.GetSearchFlags().Exclude
 		flagMap["and_"] = sc.GetSearchFlags().And
+		flagMap["exact_"] = append(flagMap["exact_"], sc.GetSearchFlags().GetTagConditions()...)
 	}
 	return flagMap
 }
@@ -99,19 +131,29 @@ func (sc *SearchCommands) GetFieldsArray() []string {
 	for _, ss := range sc.searchFlags.Fields {
 		fs = append(fs, strings.Split(ss, ",")...)
 	}
+	if sc.format != "" {
+		fs = append(fs, templateFields(sc.format)...)
+	}
 	if sc.showtags == true {
 		if sc.objectType == "nodes" {
 			fs = append(fs, "node_groups[name]")
 			fs = append(fs, "node_groups[tags][name]")
 		} else if sc.objectType == "node_groups" {
 			fs = append(fs, "tags[name]")
-		} else {
-			log.Fatalf("--showtags can only be used when searching objecttype nodes or node_groups. object type = %v\n", sc.objectType)
 		}
 	}
 	return fs
 }
 
+// CheckShowTags returns an error if --showtags is given for an object type
+// that has no tags.
+func (sc *SearchCommands) CheckShowTags() error {
+	if sc.showtags && sc.objectType != "nodes" && sc.objectType != "node_groups" {
+		return newError(ErrUsage, "--showtags can only be used when searching objecttype nodes or node_groups. object type = %v\n", sc.objectType)
+	}
+	return nil
+}
+
 func (c *SearchCommands) GetObjectType() string {
 	return c.objectType
 }
@@ -135,21 +177,32 @@ func (f *SearchCommands) InitializeCommand(app *cobra.Command) {
 	app.Flags().BoolVar(&f.noSwitchport, "no-switchport", false, "Skip switch port detection")
 	app.Flags().BoolVar(&f.noStorage, "no-storage", false, "Skip storage detection")
 
-	app.PersistentFlags().StringVar(&f.username, "username", "", "Username to use when authenticating to the server.\n\t If not specified defaults to the current user.")
+	app.PersistentFlags().StringVar(&f.username, "username", defaultUsername, "Username to use when authenticating to the server.\n\t If not specified defaults to the current user.")
+	app.PersistentFlags().StringVar(&f.passwordFile, "password-file", "", "Read the password to log in with from the first line of this file, instead of prompting for it.\n\t Without it NV_PASSWORD, --password-stdin and then netrc_file (~/.netrc) are tried.")
+	app.PersistentFlags().BoolVar(&f.passwordStdin, "password-stdin", false, "Read the password to log in with from the first line of stdin. Use --yes with it, stdin can't answer prompts too.")
 	app.PersistentFlags().StringVar(&f.server, "server", defaultServer, "Specify nventory server if different than the default")
+	app.PersistentFlags().StringVar(&f.proxy, "proxy", defaultProxy, "Proxy to reach the server through, e.g. http://proxy.example.com:8080.\n\t Defaults to proxy_server in the config file, then to HTTP_PROXY/HTTPS_PROXY.")
+	app.PersistentFlags().BoolVar(&f.insecure, "insecure", false, "Don't verify the certificate of the server. Only use it to test against servers with self-signed certificates.")
+	app.PersistentFlags().Var(newTimeoutValue(defaultTimeout, &f.timeout), "timeout", "Give up on a request to the server after this long, e.g. 30s, 2m or 30 (seconds). 0 waits forever.\n\t Defaults to timeout in the config file.")
 
 	app.PersistentFlags().StringVar(&f.objectType, "objecttype", "nodes", "Object type of search.")
 	app.PersistentFlags().BoolVar(&f.withAliases, "withaliases", false, "When searching by name, search aliases as well. (doesn't work with exactget nor regexget)")
 	app.PersistentFlags().BoolVar(&f.showtags, "showtags", false, "Lists all tags the node(s) belongs to")
+	app.PersistentFlags().StringVar(&f.output, "output", OutputText, "Output format of search results: "+strings.Join(OutputFormats, "|")+".\n\t csv and tsv use --fields as columns.")
+	app.PersistentFlags().StringVar(&f.format, "format", "", "Go template executed once per matching object, e.g. '{{.name}} {{index . \"ip_addresses[address]\"}}'.\n\t Overrides --output. Helpers: join, first, last, default.")
 	app.Flags().BoolVar(&f.allFields, "allfields", false, "Display all fields for selected objects. One or more fields may be specified to be excluded from the query, seperate multiple fields with commas.")
+	app.Flags().BoolVar(&f.fieldNames, "getfieldnames", false, "Shows get/set fields supported by server for the objecttype, with their shortcuts.\n\t Use --output json|yaml|csv|tsv for machine-readable output.")
+	app.Flags().StringSliceVar(&f.allValues, "getallvalues", nil, "Display all values stored in the database for the specified fields (field1[,field2]) of the selected objects, or of all objects if none are selected")
+	app.Flags().BoolVar(&f.countValues, "count", false, "With --getallvalues, show how many objects have each value")
+	app.Flags().StringVar(&f.sortValues, "sortby", SortByValue, "With --getallvalues, sort values by "+SortByValue+" or by "+SortByCount+" (most common first)")
 	app.PersistentFlags().BoolVar(&f.showVersion, "version", false, "print the version")
 	f.version = "0.0.0"
 
-	app.Flags().BoolVar(&f.nodeGroupNodes, "get_nodegroup_nodes", false, "Display all the members of the given node group including virtuals")
+	app.Flags().StringSliceVar(&f.nodeGroupNodes, "get_nodegroup_nodes", []string{}, "Same as --nodegroupexpanded")
 	// Aliases: []string{"ngn", "getnodegroupnodes"},
-	app.Flags().BoolVar(&f.nodeGroup, "nodegroup", false, "Display all the members of the given node group including virtuals")
+	app.Flags().StringVar(&f.nodeGroup, "nodegroup", "", "Display the members of the given node group, member groups are displayed as groups and are not expanded")
 	// Aliases: []string{"ng"},
-	app.Flags().BoolVar(&f.nodeGroupNodes, "nodegroupexpanded", false, "Display all the members of the given node group including virtuals")
+	app.Flags().StringSliceVar(&f.nodeGroupNodes, "nodegroupexpanded", []string{}, "Display all the nodes of the given node groups (nodegroup1[,nodegroup2]), member groups are expanded")
 	// Aliases: []string{"nge"},
 
 	// Get version from VERSION file.
@@ -162,6 +215,35 @@ func (f *SearchCommands) InitializeCommand(app *cobra.Command) {
 	}
 }
 
+// ParseTimeout parses a timeout like 30s or 2m, or a number of seconds, as
+// given with --timeout or in the config file.
+func ParseTimeout(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// timeoutValue is the --timeout flag, parsed with ParseTimeout.
+type timeoutValue time.Duration
+
+func newTimeoutValue(val time.Duration, p *time.Duration) *timeoutValue {
+	*p = val
+	return (*timeoutValue)(p)
+}
+
+func (t *timeoutValue) Set(s string) error {
+	d, err := ParseTimeout(s)
+	if err != nil {
+		return err
+	}
+	*t = timeoutValue(d)
+	return nil
+}
+
+func (t *timeoutValue) String() string { return time.Duration(*t).String() }
+
+func (t *timeoutValue) Type() string { return "duration" }
 
 func getVersionFromVersionFile(filename string) (string, error) {
 	b, err := ioutil.ReadFile(filename)
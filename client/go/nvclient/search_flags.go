@@ -29,6 +29,7 @@ type SearchFlags struct {
 	Exclude  []string
 	And      []string
 	Fields   []string
+	Tag      string
 }
 
 func (f *SearchFlags) Init(app *cobra.Command) {
@@ -37,8 +38,9 @@ func (f *SearchFlags) Init(app *cobra.Command) {
 	app.Flags().StringSliceVar(&f.Regexget, "regexget", nil, "Specify reglar expression to search for target item")
 	app.Flags().StringSliceVar(&f.Exclude, "exclude", nil, "Excludes substring from potential matches from get/exactget/regexget.\n\tMultiple values for an individual field can be specified seperated by commas.")
 	app.Flags().StringSliceVar(&f.And, "and", nil, "Add another condition for matching")
-	app.Flags().StringSliceVar(&f.Fields, "fields", nil, "Display the specified fields for selected objects. One or more fields may be specified, either by specifying this option multiple times or by seperating the field names with commas.")
+	app.Flags().StringSliceVar(&f.Fields, "fields", nil, "Display the specified fields for selected objects. One or more fields may be specified, either by specifying this option multiple times or by seperating the field names with commas.\n\t Use graffiti to display all graffiti, or graffiti[name] for a single one.")
 	app.Flags().StringSliceVar(&f.Name, "name", nil, "Specify partial name of target item")
+	app.Flags().StringVar(&f.Tag, "tag", "", "Select node groups tagged with the given tag (objecttype node_groups)")
 }
 
 func (f *SearchFlags) ToString() string {
@@ -57,8 +59,17 @@ func (f *SearchFlags) ToString() string {
 }
 
 func (f *SearchFlags) IsEmpty() bool {
-	if len(f.Name) > 0 || len(f.Get) > 0 || len(f.Exactget) > 0 || len(f.Regexget) > 0 || len(f.Exclude) > 0 || len(f.And) > 0 {
+	if len(f.Name) > 0 || len(f.Get) > 0 || len(f.Exactget) > 0 || len(f.Regexget) > 0 || len(f.Exclude) > 0 || len(f.And) > 0 || f.Tag != "" {
 		return false
 	}
 	return true
 }
+
+// GetTagConditions returns the exactget conditions selecting node groups by
+// --tag, if given.
+func (f *SearchFlags) GetTagConditions() []string {
+	if f.Tag == "" {
+		return []string{}
+	}
+	return []string{"tags[name]=" + f.Tag}
+}
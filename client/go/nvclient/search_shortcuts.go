@@ -15,11 +15,8 @@
 package nvclient
 
 import (
-	"log"
 	"regexp"
 
-	"errors"
-
 	"github.com/lestrrat/go-libxml2"
 	_ "github.com/lestrrat/go-libxml2/xpath"
 )
@@ -78,7 +75,7 @@ func (ss SearchShortcuts) SaveFieldShortcuts(response, xpath, nodeName string, f
 
 	d, err := libxml2.ParseString(response)
 	if err != nil {
-		log.Fatal("Unable to parse response as xml:\n%v", response)
+		return nil, wrapError(ErrParse, err, "Unable to parse response as xml:\n%v\n", response)
 	}
 	xPathResult, err := d.Find(xpath)
 
@@ -101,7 +98,7 @@ func (ss SearchShortcuts) SaveFieldShortcuts(response, xpath, nodeName string, f
 		}
 	}
 	if !found {
-		return result, errors.New("No matching objects\n")
+		return result, newError(ErrNotFound, "No matching objects\n")
 	}
 	return result, nil
 }
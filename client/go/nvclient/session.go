@@ -0,0 +1,224 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	logger "github.com/atclate/go-logger"
+)
+
+// accountLink matches the link to the account of the session in the header
+// of the server's pages, as in "Welcome back, <a href="/accounts/7">...".
+var accountLink = regexp.MustCompile(`(?s)id="account_links".*?href="[^"]*/accounts/(\d+)"`)
+
+/*******
+ * Session describes the login session of a user, as kept in its cookie file
+ * between runs.
+ *******/
+type Session struct {
+	Login      string // the account the server has the session for
+	Server     string
+	SSOHost    string // empty unless the session comes from the SSO server
+	LoggedIn   bool
+	Expires    time.Time // zero if none of the cookies expire
+	CookieFile string
+}
+
+func (s *Session) String() string {
+	if !s.LoggedIn {
+		return fmt.Sprintf("Not logged in to %v as %v.\n", s.Server, s.Login)
+	}
+	result := fmt.Sprintf("Logged in to %v as %v.\n", s.Server, s.Login)
+	if s.SSOHost != "" {
+		result += fmt.Sprintf("SSO server: %v\n", s.SSOHost)
+	}
+	if s.Expires.IsZero() {
+		result += "Session expires: when the server ends it\n"
+	} else {
+		result += fmt.Sprintf("Session expires: %v\n", s.Expires.Local().Format(time.RFC1123))
+	}
+	return result + fmt.Sprintf("Cookie file: %v\n", s.CookieFile)
+}
+
+// Whoami returns the session in the cookie file of username, without logging
+// in. The account of the session is the one the server reports, which may
+// not be username when users share a cookie file.
+func (f *NventoryClient) Whoami(username string) (*Session, error) {
+	ctx := f.context()
+	httpClient := f.HttpClient.createBlankHttpClient()
+	f.HttpClient.cookieJarFor(username).LoadInto(httpClient)
+
+	loggedIn, _, err := f.HttpClient.isLoggedIn(ctx, f.HttpClient.GetServer(), httpClient, f.HttpClient.authenticatorFor(username))
+	if err != nil {
+		return nil, requestError(ctx, err, f.HttpClient.GetServer())
+	}
+	f.SetServer(f.HttpClient.GetServer())
+
+	session := &Session{Login: username, Server: f.GetServer(), CookieFile: getCookieFilename(username)}
+	cookies, err := f.HttpClient.cookieJarFor(username).Cookies()
+	if err != nil {
+		logger.Error.Printf("Unable to read cookie file %v: %v\n", session.CookieFile, err)
+	}
+	if !loggedIn || len(cookies) == 0 {
+		return session, nil
+	}
+	login, err := f.sessionAccount(ctx, httpClient)
+	if err != nil || login == "" {
+		return session, err
+	}
+	session.Login = login
+	session.LoggedIn = true
+	serverHost := ""
+	if u, err := url.Parse(session.Server); err == nil {
+		serverHost = u.Hostname()
+	}
+	for _, c := range cookies {
+		if c.Domain != "" && c.Domain != serverHost && f.HttpClient.isSSODomain(c.Domain) {
+			session.SSOHost = c.Domain
+		}
+		if !c.Expires.IsZero() && (session.Expires.IsZero() || c.Expires.Before(session.Expires)) {
+			session.Expires = c.Expires
+		}
+	}
+	return session, nil
+}
+
+// sessionAccount returns the login of the account httpClient has a session
+// for, which the server links to in the header of the dashboard, or "" if it
+// shows none.
+func (f *NventoryClient) sessionAccount(ctx context.Context, httpClient *http.Client) (string, error) {
+	page, err := getPage(ctx, httpClient, f.GetServer()+"/dashboard")
+	if err != nil {
+		return "", err
+	}
+	m := accountLink.FindStringSubmatch(page)
+	if m == nil {
+		logger.Debug.Printf("No account in the dashboard of %v\n", f.GetServer())
+		return "", nil
+	}
+
+	response, err := getPage(ctx, httpClient, fmt.Sprintf("%v/accounts/%v.xml", f.GetServer(), m[1]))
+	if err != nil {
+		return "", err
+	}
+	res, err := GetResultsFromResponse(response)
+	if err != nil {
+		return "", err
+	}
+	for _, account := range getResultMapsOf(res) {
+		if login := getResultValue(account, "login"); login != "" {
+			return login, nil
+		}
+	}
+	return "", newError(ErrParse, "No login in account %v of %v.\n", m[1], f.GetServer())
+}
+
+// getPage GETs u with httpClient and returns the body of the response, or an
+// error of the kind matching its status.
+func getPage(ctx context.Context, httpClient *http.Client, u string) (string, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", wrapError(ErrUsage, err, "Invalid URL %v: %v\n", u, err)
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", requestError(ctx, err, u)
+	}
+	body, err := readResponseBody(resp.Body)
+	if err != nil {
+		return "", wrapError(ErrServer, err, "Unable to read response body from %v: %v\n", u, err)
+	}
+	return body, checkResponse(resp, u)
+}
+
+// Login logs in as username unless its session is still valid, saving the
+// session in its cookie file. The session of another account in the cookie
+// file is replaced.
+func (f *NventoryClient) Login(username string) (*Session, error) {
+	session, err := f.Whoami(username)
+	if err != nil || (session.LoggedIn && session.Login == username) {
+		return session, err
+	}
+	if session.LoggedIn {
+		logger.Debug.Printf("Replacing the session of %v in %v\n", session.Login, session.CookieFile)
+		if err := f.HttpClient.cookieJarFor(username).Clear(); err != nil {
+			return nil, wrapError(ErrAuth, err, "Unable to remove cookie file %v: %v\n", session.CookieFile, err)
+		}
+	}
+	delete(f.HttpClient.httpClientMap, username)
+	if _, err := f.GetHttpClientFor(username); err != nil {
+		return nil, err
+	}
+	session, err = f.Whoami(username)
+	if err == nil && (!session.LoggedIn || session.Login != username) {
+		err = newError(ErrAuth, "Unable to log in to %v as %v.\n", session.Server, username)
+	}
+	return session, err
+}
+
+// Logout ends the session of username on the SSO server, at the URL set with
+// SetSSOLogoutURL, or on the nventory server for local accounts, then removes
+// its cookies for the server. The cookies are removed even if the servers
+// can't be reached.
+func (f *NventoryClient) Logout(username string) error {
+	session, err := f.Whoami(username)
+	if err != nil {
+		logger.Debug.Printf("Unable to check the session of %v: %v\n", username, err)
+		session = &Session{Login: username, Server: f.GetServer(), CookieFile: getCookieFilename(username)}
+	}
+
+	if session.LoggedIn {
+		httpClient := f.HttpClient.createBlankHttpClient()
+		f.HttpClient.cookieJarFor(username).LoadInto(httpClient)
+		if err := f.endSession(httpClient, session); err != nil {
+			logger.Error.Printf("Unable to end the session, removing the cookies anyway: %v\n", err)
+		}
+	}
+
+	delete(f.HttpClient.httpClientMap, username)
+	if err := f.HttpClient.cookieJarFor(username).Clear(); err != nil {
+		return wrapError(ErrAuth, err, "Unable to remove cookie file %v: %v\n", session.CookieFile, err)
+	}
+	return nil
+}
+
+// endSession ends session on the SSO server, or on the nventory server for
+// local accounts.
+func (f *NventoryClient) endSession(httpClient *http.Client, session *Session) error {
+	if session.SSOHost == "" {
+		u := fmt.Sprintf("%v/login/logout", session.Server)
+		logger.Debug.Printf("Ending session at %v\n", u)
+		resp, err := postForm(f.context(), httpClient, u, url.Values{})
+		if err != nil {
+			return requestError(f.context(), err, u)
+		}
+		readResponseBody(resp.Body)
+		return nil
+	}
+
+	if f.HttpClient.ssoLogoutURL == "" {
+		return newError(ErrUsage, "sso_logout_url isn't set, the session on %v can't be ended.\n", session.SSOHost)
+	}
+	logger.Debug.Printf("Ending session at %v\n", f.HttpClient.ssoLogoutURL)
+	// the logout of the SSO server is a link, followed with GET
+	_, err := getPage(f.context(), httpClient, f.HttpClient.ssoLogoutURL)
+	return err
+}
@@ -29,6 +29,22 @@ SetCommands:
 type SetCommands struct {
 	setValueFlags *SetValueFlags // cli flag (--set) for setting a value
 	searchCommand *SearchCommands // misc cli flags related to searching
+	delete        bool            // cli flag (--delete) for deleting selected objects
+
+	addToNodeGroup      []string // cli flag (--addtonodegroup)
+	removeFromNodeGroup []string // cli flag (--removefromnodegroup)
+	createNodeGroup     []string // cli flag (--createnodegroup)
+
+	addNodeGroupToNodeGroup      []string // cli flag (--addnodegrouptonodegroup child_group,parent_group)
+	removeNodeGroupFromNodeGroup []string // cli flag (--removenodegroupfromnodegroup child_group,parent_group)
+
+	createTag              []string // cli flag (--createtag)
+	addTagToNodeGroup      []string // cli flag (--addtagtonodegroup tag,node_group)
+	removeTagFromNodeGroup []string // cli flag (--removetagfromnodegroup tag,node_group)
+
+	addGraffiti    string // cli flag (--addgraffiti name:value)
+	deleteGraffiti string // cli flag (--deletegraffiti name)
+	addComment     string // cli flag (--addcomment)
 
 	driver Driver
 }
@@ -55,6 +71,54 @@ func (c *SetCommands) GetSearchCommands() *SearchCommands {
 	return c.searchCommand
 }
 
+func (c *SetCommands) IsDelete() bool {
+	return c.delete
+}
+
+func (c *SetCommands) GetAddToNodeGroup() []string {
+	return c.addToNodeGroup
+}
+
+func (c *SetCommands) GetRemoveFromNodeGroup() []string {
+	return c.removeFromNodeGroup
+}
+
+func (c *SetCommands) GetCreateNodeGroup() []string {
+	return c.createNodeGroup
+}
+
+func (c *SetCommands) GetAddNodeGroupToNodeGroup() []string {
+	return c.addNodeGroupToNodeGroup
+}
+
+func (c *SetCommands) GetRemoveNodeGroupFromNodeGroup() []string {
+	return c.removeNodeGroupFromNodeGroup
+}
+
+func (c *SetCommands) GetCreateTag() []string {
+	return c.createTag
+}
+
+func (c *SetCommands) GetAddTagToNodeGroup() []string {
+	return c.addTagToNodeGroup
+}
+
+func (c *SetCommands) GetRemoveTagFromNodeGroup() []string {
+	return c.removeTagFromNodeGroup
+}
+
+func (c *SetCommands) GetAddGraffiti() string {
+	return c.addGraffiti
+}
+
+func (c *SetCommands) GetDeleteGraffiti() string {
+	return c.deleteGraffiti
+}
+
+func (c *SetCommands) GetAddComment() string {
+	return c.addComment
+}
+
 func (c *SetCommands) GetObjectType() string {
 	return c.searchCommand.GetObjectType()
 }
@@ -67,6 +131,7 @@ func (sc *SetCommands) GetFlagMap() map[string][]string {
 		flagMap["regex_"] = sc.GetSearchFlags().Regexget
 		flagMap["exclude_"] = sc.GetSearchFlags().Exclude
 		flagMap["and_"] = sc.GetSearchFlags().And
+		flagMap["exact_"] = append(flagMap["exact_"], sc.GetSearchFlags().GetTagConditions()...)
 	}
 	return flagMap
 }
@@ -82,14 +147,97 @@ func (sc *SetCommands) GetFieldsArray() []string {
 func (sc *SetCommands) SetByCommand(f Driver) (string, error) {
 	flagMap := sc.GetFlagMap()
 
-	fs := sc.GetSetFromFlags()
+	fs, err := sc.GetSetFromFlags()
+	if err != nil {
+		return "", err
+	}
 
 	i, _ := f.GetAllSubsystemNames(sc.GetObjectType())
 	return f.Set(sc.GetObjectType(), flagMap, i, fs, sc.GetSearchCommands().IsYes())
 }
 
+func (sc *SetCommands) DeleteByCommand(f Driver) (string, error) {
+	flagMap := sc.GetFlagMap()
+
+	return f.Delete(sc.GetObjectType(), flagMap, []string{}, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) AddToNodeGroupByCommand(f Driver) (string, error) {
+	if sc.GetObjectType() != "nodes" {
+		return "", newError(ErrUsage, "--addtonodegroup can only be used with objecttype nodes. object type = %v\n", sc.GetObjectType())
+	}
+	return f.AddNodesToNodeGroups(sc.GetFlagMap(), sc.addToNodeGroup, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) RemoveFromNodeGroupByCommand(f Driver) (string, error) {
+	if sc.GetObjectType() != "nodes" {
+		return "", newError(ErrUsage, "--removefromnodegroup can only be used with objecttype nodes. object type = %v\n", sc.GetObjectType())
+	}
+	return f.RemoveNodesFromNodeGroups(sc.GetFlagMap(), sc.removeFromNodeGroup, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) CreateNodeGroupByCommand(f Driver) (string, error) {
+	return f.CreateNodeGroups(sc.createNodeGroup, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) AddNodeGroupToNodeGroupByCommand(f Driver) (string, error) {
+	if len(sc.addNodeGroupToNodeGroup) != 2 {
+		return "", newError(ErrUsage, "--addnodegrouptonodegroup expects child_group,parent_group. (%v given)\n", strings.Join(sc.addNodeGroupToNodeGroup, ","))
+	}
+	return f.AddNodeGroupsToNodeGroups(sc.addNodeGroupToNodeGroup[:1], sc.addNodeGroupToNodeGroup[1:], sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) RemoveNodeGroupFromNodeGroupByCommand(f Driver) (string, error) {
+	if len(sc.removeNodeGroupFromNodeGroup) != 2 {
+		return "", newError(ErrUsage, "--removenodegroupfromnodegroup expects child_group,parent_group. (%v given)\n", strings.Join(sc.removeNodeGroupFromNodeGroup, ","))
+	}
+	return f.RemoveNodeGroupsFromNodeGroups(sc.removeNodeGroupFromNodeGroup[:1], sc.removeNodeGroupFromNodeGroup[1:], sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) CreateTagByCommand(f Driver) (string, error) {
+	return f.CreateTags(sc.createTag, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) AddTagToNodeGroupByCommand(f Driver) (string, error) {
+	if len(sc.addTagToNodeGroup) != 2 {
+		return "", newError(ErrUsage, "--addtagtonodegroup expects tag,node_group. (%v given)\n", strings.Join(sc.addTagToNodeGroup, ","))
+	}
+	return f.AddTagToNodeGroups(sc.addTagToNodeGroup[0], sc.addTagToNodeGroup[1:], sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) RemoveTagFromNodeGroupByCommand(f Driver) (string, error) {
+	if len(sc.removeTagFromNodeGroup) != 2 {
+		return "", newError(ErrUsage, "--removetagfromnodegroup expects tag,node_group. (%v given)\n", strings.Join(sc.removeTagFromNodeGroup, ","))
+	}
+	return f.RemoveTagFromNodeGroups(sc.removeTagFromNodeGroup[0], sc.removeTagFromNodeGroup[1:], sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) AddGraffitiByCommand(f Driver) (string, error) {
+	return f.AddGraffiti(sc.GetObjectType(), sc.GetFlagMap(), sc.addGraffiti, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) DeleteGraffitiByCommand(f Driver) (string, error) {
+	return f.DeleteGraffiti(sc.GetObjectType(), sc.GetFlagMap(), sc.deleteGraffiti, sc.GetSearchCommands().IsYes())
+}
+
+func (sc *SetCommands) AddCommentByCommand(f Driver) (string, error) {
+	return f.AddComment(sc.GetObjectType(), sc.GetFlagMap(), sc.addComment, sc.GetSearchCommands().IsYes())
+}
+
 func (f *SetCommands) Init(app *cobra.Command) {
 	app.Flags().StringSliceVar(&f.setValueFlags.value, "set", nil, "Update fields in objects selected via get/exactget, may be specified multiple times to update multiple fields.")
+	app.Flags().BoolVar(&f.delete, "delete", false, "Delete the object(s) selected via get/exactget/regexget/exclude.")
+	app.Flags().StringSliceVar(&f.addToNodeGroup, "addtonodegroup", nil, "Add nodes selected via get/exactget/regexget/exclude to one or more node groups")
+	app.Flags().StringSliceVar(&f.removeFromNodeGroup, "removefromnodegroup", nil, "Remove nodes selected via get/exactget/regexget/exclude from one or more node groups")
+	app.Flags().StringSliceVar(&f.createNodeGroup, "createnodegroup", nil, "Create one or more node groups")
+	app.Flags().StringSliceVar(&f.addNodeGroupToNodeGroup, "addnodegrouptonodegroup", nil, "Takes a child group and parent group (child_group,parent_group), the child group is added as a member of the parent group")
+	app.Flags().StringVar(&f.addGraffiti, "addgraffiti", "", "Add graffiti (\"name:value\") to the object(s) selected via get/exactget/regexget/exclude")
+	app.Flags().StringVar(&f.deleteGraffiti, "deletegraffiti", "", "Delete the named graffiti from the object(s) selected via get/exactget/regexget/exclude")
+	app.Flags().StringVar(&f.addComment, "addcomment", "", "Add a comment to the object(s) selected via get/exactget/regexget/exclude")
+	app.Flags().StringSliceVar(&f.createTag, "createtag", nil, "Create one or more tags by name")
+	app.Flags().StringSliceVar(&f.addTagToNodeGroup, "addtagtonodegroup", nil, "Adds a tag to a node group (tag,node_group), the tag is created if it doesn't exist")
+	app.Flags().StringSliceVar(&f.removeTagFromNodeGroup, "removetagfromnodegroup", nil, "Removes a tag from a node group (tag,node_group)")
+	app.Flags().StringSliceVar(&f.removeNodeGroupFromNodeGroup, "removenodegroupfromnodegroup", nil, "Takes a child group and parent group (child_group,parent_group), the child group is removed from the parent group")
 }
 
 
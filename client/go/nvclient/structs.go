@@ -19,8 +19,6 @@ import (
 	"github.com/lestrrat/go-libxml2"
 	"github.com/lestrrat/go-libxml2/clib"
 	"github.com/lestrrat/go-libxml2/types"
-	"github.com/atclate/go-logger"
-	"os"
 )
 
 /*******
@@ -169,13 +167,12 @@ func GetResultsFromResponse(response string) (Result, error) {
 
 	d, err := libxml2.ParseString(response)
 	if err != nil {
-		logger.Error.Fatal("Unable to parse response as xml:\n%v", response)
-		os.Exit(1)
+		return nil, wrapError(ErrParse, err, "Unable to parse response as xml:\n%v\n", response)
 	}
 
 	root, err := d.DocumentElement()
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrParse, err, "Unable to parse response as xml:\n%v\n", response)
 	}
 
 	result, err := getResultFromDom(root)
@@ -347,6 +344,9 @@ func shouldPrint(name string, fields []string) bool {
 				return true
 			} else if name == f {
 				return true
+			} else if f == "graffiti" && strings.HasPrefix(name, "graffiti[") {
+				// all of the graffiti, e.g. graffiti[owner]
+				return true
 			} else if f == "*" {
 				return true
 			}
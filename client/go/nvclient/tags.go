@@ -0,0 +1,175 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CreateTags creates a tag for each name.
+func (f *NventoryClient) CreateTags(tags []string, login string, noPrompt bool) (string, error) {
+	plan := make([]mutation, 0, len(tags))
+	for _, name := range tags {
+		values := getSetValues(singularize("tags"), map[string]string{"name": name})
+		plan = append(plan, mutation{name: name, method: "POST", url: f.getCreateUrl("tags", values.Encode()), values: values})
+	}
+
+	return f.applyMutations(plan, fmt.Sprintf("This will create new tag(s) (%v), continue?  [y/N]: ", strings.Join(tags, ",")), login, noPrompt, "creation")
+}
+
+// AddTagToNodeGroups tags each of the named node groups through the taggings
+// controller. Like the ruby client, the tag is created first if it doesn't
+// exist yet, confirmed with the taggings in one prompt.
+func (f *NventoryClient) AddTagToNodeGroups(tag string, nodeGroups []string, login string, noPrompt bool) (string, error) {
+	groups, err := f.getNodeGroupsByName(nodeGroups)
+	if err != nil {
+		return "", err
+	}
+
+	notes := ""
+	tagID, err := f.getTagID(tag)
+	if err != nil {
+		return "", err
+	}
+	untagged := make([]*ResultMap, 0, len(groups))
+	for _, group := range groups {
+		if tagID != "" {
+			taggings, err := f.getNodeGroupTaggings(getResultValue(group, "id"), tagID)
+			if err != nil {
+				return notes + "Unable to get taggings.", err
+			}
+			if len(taggings) > 0 {
+				notes += fmt.Sprintf("%v is already tagged with %v, skipping.\n", getResultName(group), tag)
+				continue
+			}
+		}
+		untagged = append(untagged, group)
+	}
+
+	plan := make([]mutation, 0, len(untagged)+1)
+	prompt := fmt.Sprintf("This will tag %v node group(s) with %v, continue?  [y/N]: ", len(untagged), tag)
+	if tagID == "" {
+		values := getSetValues(singularize("tags"), map[string]string{"name": tag})
+		plan = append(plan, mutation{name: tag, method: "POST", url: f.getCreateUrl("tags", values.Encode()), values: values})
+		prompt = fmt.Sprintf("This will create new tag (%v) and tag %v node group(s) with it, continue?  [y/N]: ", tag, len(untagged))
+	}
+	if f.dryRun || len(untagged) == 0 {
+		planID := tagID
+		if planID == "" {
+			planID = "(new)"
+		}
+		msg, err := f.applyMutations(append(plan, f.taggingPlan(tag, untagged, planID)...), prompt, login, noPrompt, "tagging")
+		return notes + msg, err
+	}
+	if !noPrompt && !f.confirm(prompt) {
+		return notes + fmt.Sprintln("Cancelled"), f.canceled()
+	}
+
+	if tagID == "" {
+		msg, err := f.applyMutations(plan, "", login, true, "creation")
+		notes += msg
+		if err != nil {
+			return notes, err
+		}
+		if tagID, err = f.getTagID(tag); err != nil {
+			return notes, err
+		}
+		if tagID == "" {
+			return notes, newError(ErrNotFound, "Tag '%v' not found after creating it.\n", tag)
+		}
+	}
+	msg, err := f.applyMutations(f.taggingPlan(tag, untagged, tagID), "", login, true, "tagging")
+	return notes + msg, err
+}
+
+// taggingPlan returns the POSTs tagging each of the node groups with the tag
+// of tagID.
+func (f *NventoryClient) taggingPlan(tag string, groups []*ResultMap, tagID string) []mutation {
+	plan := make([]mutation, 0, len(groups))
+	for _, group := range groups {
+		values := url.Values{}
+		values.Set("tagging[taggable_type]", "NodeGroup")
+		values.Set("tagging[taggable_id]", getResultValue(group, "id"))
+		values.Set("tagging[tag_id]", tagID)
+		plan = append(plan, mutation{
+			name:   tag + " => " + getResultName(group),
+			method: "POST",
+			url:    f.getCreateUrl("taggings", values.Encode()),
+			values: values,
+		})
+	}
+	return plan
+}
+
+// RemoveTagFromNodeGroups deletes the taggings of the tag from each of the
+// named node groups.
+func (f *NventoryClient) RemoveTagFromNodeGroups(tag string, nodeGroups []string, login string, noPrompt bool) (string, error) {
+	groups, err := f.getNodeGroupsByName(nodeGroups)
+	if err != nil {
+		return "", err
+	}
+	tagID, err := f.getTagID(tag)
+	if err != nil {
+		return "", err
+	}
+	if tagID == "" {
+		return "", newError(ErrNotFound, "Tag '%v' not found.\n", tag)
+	}
+
+	notes := ""
+	plan := make([]mutation, 0)
+	for _, group := range groups {
+		taggings, err := f.getNodeGroupTaggings(getResultValue(group, "id"), tagID)
+		if err != nil {
+			return "Unable to get taggings.", err
+		}
+		if len(taggings) == 0 {
+			notes += fmt.Sprintf("%v is not tagged with %v, skipping.\n", getResultName(group), tag)
+			continue
+		}
+		for _, tagging := range taggings {
+			id := getResultValue(tagging, "id")
+			plan = append(plan, mutation{
+				id:     id,
+				name:   tag + " => " + getResultName(group),
+				method: "DELETE",
+				url:    f.getDeleteUrl("taggings", id),
+			})
+		}
+	}
+
+	msg, err := f.applyMutations(plan, fmt.Sprintf("This will remove tag %v from %v node group(s), continue?  [y/N]: ", tag, len(groups)), login, noPrompt, "removal")
+	return notes + msg, err
+}
+
+// getTagID returns the id of the tag with the given name, or "" if there is
+// no such tag.
+func (f *NventoryClient) getTagID(name string) (string, error) {
+	tags, err := f.getResultMaps("tags", Conditions{"exact_": []string{"name=" + name}}, []string{})
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", nil
+	}
+	return getResultValue(tags[0], "id"), nil
+}
+
+// getNodeGroupTaggings returns the taggings of the tag on the node group.
+func (f *NventoryClient) getNodeGroupTaggings(groupID string, tagID string) ([]*ResultMap, error) {
+	return f.getResultMaps("taggings", Conditions{"exact_": []string{"taggable_type=NodeGroup,taggable_id=" + groupID + ",tag_id=" + tagID}}, []string{})
+}
@@ -0,0 +1,274 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+var templateFuncs = template.FuncMap{
+	"join":    templateJoin,
+	"first":   templateFirst,
+	"last":    templateLast,
+	"default": templateDefault,
+}
+
+// FormatResultsWithTemplate executes the --format template once for every
+// object in r. Each object is a map holding the nested fields (.name,
+// .hardware_profile.name) plus flattened keys for use with index, e.g.
+// {{index . "ip_addresses[address]"}}. A flattened key holds a string, or a
+// []string when the object has several values for it. Fields the object
+// doesn't have are printed empty.
+func FormatResultsWithTemplate(r Result, format string) (string, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return "", err
+	}
+
+	objects := make([]Result, 0)
+	switch t := r.(type) {
+	case *ResultArray:
+		objects = t.Array
+	case *ResultMap:
+		objects = append(objects, t)
+	}
+
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		start := buf.Len()
+		data := templateData(obj)
+		if m, ok := data.(map[string]interface{}); ok {
+			fillMissingFields(tmpl.Tree.Root, m)
+		}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return buf.String(), err
+		}
+		if buf.Len() > start && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), nil
+}
+
+// ResultToInterface converts a Result tree into plain maps, slices and strings.
+func ResultToInterface(r Result) interface{} {
+	switch t := r.(type) {
+	case *ResultArray:
+		arr := make([]interface{}, 0, len(t.Array))
+		for _, elm := range t.Array {
+			arr = append(arr, ResultToInterface(elm))
+		}
+		return arr
+	case *ResultMap:
+		m := make(map[string]interface{}, len(t.Map))
+		for _, k := range t.GetOrder() {
+			m[k] = ResultToInterface(t.Get(k))
+		}
+		return m
+	case *ResultValue:
+		return t.Value
+	}
+	return nil
+}
+
+func templateData(r Result) interface{} {
+	data := ResultToInterface(r)
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	row := newFlatRow()
+	flattenResult(r, "", row)
+	for _, k := range row.order {
+		if !strings.Contains(k, "[") {
+			continue
+		}
+		if len(row.values[k]) == 1 {
+			m[k] = row.values[k][0]
+		} else {
+			m[k] = row.values[k]
+		}
+	}
+	return m
+}
+
+// fillMissingFields sets the fields node refers to on the object m, which m
+// doesn't have, to "", so they are printed empty instead of as <no value>.
+// The bodies of range and with are skipped since they don't run on m.
+func fillMissingFields(node parse.Node, m map[string]interface{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, elm := range n.Nodes {
+			fillMissingFields(elm, m)
+		}
+	case *parse.ActionNode:
+		fillMissingFields(n.Pipe, m)
+	case *parse.TemplateNode:
+		fillMissingFields(n.Pipe, m)
+	case *parse.IfNode:
+		fillMissingFields(n.Pipe, m)
+		fillMissingFields(n.List, m)
+		fillMissingFields(n.ElseList, m)
+	case *parse.RangeNode:
+		fillMissingFields(n.Pipe, m)
+		fillMissingFields(n.ElseList, m)
+	case *parse.WithNode:
+		fillMissingFields(n.Pipe, m)
+		fillMissingFields(n.ElseList, m)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			fillMissingFields(cmd, m)
+		}
+	case *parse.CommandNode:
+		// {{index . "ip_addresses[address]"}}
+		if len(n.Args) == 3 && n.Args[0].String() == "index" && n.Args[1].Type() == parse.NodeDot {
+			if key, ok := n.Args[2].(*parse.StringNode); ok {
+				fillMissingField(m, []string{key.Text})
+			}
+		}
+		for _, arg := range n.Args {
+			fillMissingFields(arg, m)
+		}
+	case *parse.FieldNode:
+		fillMissingField(m, n.Ident)
+	}
+}
+
+// fillMissingField sets the field at path in m to "" if m doesn't have it.
+func fillMissingField(m map[string]interface{}, path []string) {
+	for i, name := range path {
+		v := m[name]
+		if i == len(path)-1 {
+			if v == nil {
+				m[name] = ""
+			}
+			return
+		}
+		if v == nil {
+			v = make(map[string]interface{})
+			m[name] = v
+		}
+		next, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = next
+	}
+}
+
+// templateFields returns the field[subfield] names a --format template
+// refers to, so they can be included in the search.
+func templateFields(format string) []string {
+	fields := make([]string, 0)
+	for _, m := range regexp.MustCompile(`"([a-z_]+(\[[a-z_]+\])+)"`).FindAllStringSubmatch(format, -1) {
+		fields = append(fields, m[1])
+	}
+	for _, m := range regexp.MustCompile(`\.([a-z_]+(\.[a-z_]+)+)`).FindAllStringSubmatch(format, -1) {
+		parts := strings.Split(m[1], ".")
+		fields = append(fields, parts[0]+"["+strings.Join(parts[1:], "][")+"]")
+	}
+	return fields
+}
+
+func toStringSlice(v interface{}) []string {
+	switch t := v.(type) {
+	case nil:
+		return []string{}
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []interface{}:
+		result := make([]string, 0, len(t))
+		for _, elm := range t {
+			result = append(result, fmt.Sprint(elm))
+		}
+		return result
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+func templateJoin(v interface{}, sep string) string {
+	return strings.Join(toStringSlice(v), sep)
+}
+
+func templateFirst(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		if len(t) > 0 {
+			return t[0]
+		}
+		return ""
+	case []interface{}:
+		if len(t) > 0 {
+			return t[0]
+		}
+		return ""
+	}
+	return v
+}
+
+func templateLast(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		if len(t) > 0 {
+			return t[len(t)-1]
+		}
+		return ""
+	case []interface{}:
+		if len(t) > 0 {
+			return t[len(t)-1]
+		}
+		return ""
+	}
+	return v
+}
+
+// templateDefault returns def when v is missing or empty, so it can be used
+// as {{.serial_number | default "unknown"}}.
+func templateDefault(def interface{}, v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return def
+	case string:
+		if t == "" {
+			return def
+		}
+	case []string:
+		if len(t) == 0 {
+			return def
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			return def
+		}
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	logger "github.com/atclate/go-logger"
+)
+
+/*******
+ * TLSOptions configures how the client verifies the server, like the ca_file
+ * and ca_path settings of nventory.conf. Without CAFile or CAPath the system
+ * CAs are trusted. Insecure turns verification off and must be asked for.
+ *******/
+type TLSOptions struct {
+	CAFile     string // PEM file of the CAs to trust
+	CAPath     string // directory of PEM files of the CAs to trust
+	ClientCert string // PEM certificate presented to the server, with ClientKey
+	ClientKey  string
+	Insecure   bool
+}
+
+// newTLSConfig returns the tls.Config for opts. Like the ruby client, a
+// CAFile or CAPath that doesn't exist is ignored.
+func newTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.Insecure {
+		logger.Debug.Println("Not verifying the certificates of servers (--insecure)")
+	}
+
+	var pool *x509.CertPool
+	if opts.CAFile != "" {
+		if _, err := os.Stat(opts.CAFile); err == nil {
+			pool = x509.NewCertPool()
+			if err := addCAFile(pool, opts.CAFile); err != nil {
+				return nil, err
+			}
+		} else {
+			logger.Debug.Printf("ca_file %v not found, ignoring it: %v\n", opts.CAFile, err)
+		}
+	}
+	if opts.CAPath != "" {
+		if files, err := ioutil.ReadDir(opts.CAPath); err == nil {
+			if pool == nil {
+				pool = x509.NewCertPool()
+			}
+			for _, file := range files {
+				if file.IsDir() {
+					continue
+				}
+				// ca_path directories also hold CRLs and other files, so only
+				// the certificates found are used.
+				if err := addCAFile(pool, filepath.Join(opts.CAPath, file.Name())); err != nil {
+					logger.Debug.Printf("Skipping %v: %v", file.Name(), err)
+				}
+			}
+		} else {
+			logger.Debug.Printf("ca_path %v not found, ignoring it: %v\n", opts.CAPath, err)
+		}
+	}
+	config.RootCAs = pool
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, newError(ErrUsage, "Both client_cert and client_key are needed to present a client certificate.\n")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, wrapError(ErrUsage, err, "Unable to load client certificate %v: %v\n", opts.ClientCert, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// addCAFile adds the certificates of the PEM file to pool.
+func addCAFile(pool *x509.CertPool, filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return wrapError(ErrUsage, err, "Unable to read CA file %v: %v\n", filename, err)
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return newError(ErrUsage, "No certificates found in CA file %v\n", filename)
+	}
+	logger.Debug.Printf("Trusting CAs from %v\n", filename)
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nvclient
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	SortByValue = "value"
+	SortByCount = "count"
+)
+
+/*******
+ * FieldValues holds the distinct values of one field across the matched
+ * objects, as listed by --getallvalues.
+ *******/
+type FieldValues struct {
+	Field  string
+	Values []ValueCount
+}
+
+// ValueCount is a distinct value and the number of objects that have it.
+type ValueCount struct {
+	Value string
+	Count int
+}
+
+// GetAllValues collects the distinct values of each field in r. Values of
+// nested arrays (e.g. every ip_addresses[address] of a node) are counted
+// once per object. sortBy is SortByValue or SortByCount (most common first).
+func GetAllValues(r Result, fields []string, sortBy string) ([]FieldValues, error) {
+	if sortBy != SortByValue && sortBy != SortByCount {
+		return nil, newError(ErrUsage, "Unknown sort order %v, expected %v or %v\n", sortBy, SortByValue, SortByCount)
+	}
+
+	counts := make(map[string]map[string]int, 0)
+	for _, field := range fields {
+		counts[search_shortcuts.Replace(field)] = make(map[string]int, 0)
+	}
+	for _, obj := range getResultMapsOf(r) {
+		row := newFlatRow()
+		flattenResult(obj, "", row)
+		for key, c := range counts {
+			seen := make(map[string]bool, 0)
+			for _, v := range row.values[key] {
+				if v != "" && !seen[v] {
+					seen[v] = true
+					c[v]++
+				}
+			}
+		}
+	}
+
+	result := make([]FieldValues, 0, len(fields))
+	for _, field := range fields {
+		fv := FieldValues{Field: field, Values: make([]ValueCount, 0)}
+		for v, n := range counts[search_shortcuts.Replace(field)] {
+			fv.Values = append(fv.Values, ValueCount{Value: v, Count: n})
+		}
+		sort.Slice(fv.Values, func(i, j int) bool {
+			a, b := fv.Values[i], fv.Values[j]
+			if sortBy == SortByCount && a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Value < b.Value
+		})
+		result = append(result, fv)
+	}
+	return result, nil
+}
+
+// PrintAllValues prints each field followed by its values, like the ruby
+// client, optionally with the number of objects having each value.
+func PrintAllValues(values []FieldValues, withCount bool) string {
+	result := ""
+	for _, fv := range values {
+		result += fv.Field + ":\n"
+		for _, v := range fv.Values {
+			if withCount {
+				result += fmt.Sprintf("  %v (%v)\n", v.Value, v.Count)
+			} else {
+				result += fmt.Sprintf("  %v\n", v.Value)
+			}
+		}
+		result += "\n"
+	}
+	return result
+}
+
+// AllValuesToResult converts field values into a Result, so they can be
+// printed with --output or --format like search results.
+func AllValuesToResult(values []FieldValues, withCount bool) Result {
+	arr := &ResultArray{Name: "values", Array: make([]Result, 0)}
+	for _, fv := range values {
+		for _, v := range fv.Values {
+			m := &ResultMap{Name: fv.Field}
+			m.Add("field", &ResultValue{Name: "field", Value: fv.Field})
+			m.Add("value", &ResultValue{Name: "value", Value: v.Value})
+			if withCount {
+				m.Add("count", &ResultValue{Name: "count", Value: strconv.Itoa(v.Count)})
+			}
+			arr.Array = append(arr.Array, m)
+		}
+	}
+	return arr
+}
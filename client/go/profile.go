@@ -0,0 +1,95 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	logger "github.com/atclate/go-logger"
+	"github.com/atclate/nventory/client/go/nvclient"
+	"github.com/spf13/cobra"
+)
+
+/******************************************************************************
+SetupProfileCommand:
+	Adds the profile command listing the profiles of the config files, and
+	setting the one used by default in ~/.nventory.conf.
+ *****************************************************************************/
+func SetupProfileCommand(app *cobra.Command) {
+	profileCmd := &cobra.Command{
+		Use:   "profile",
+		Short: "List the profiles of the config files, or set the default one",
+		Long: `Profiles hold the settings of one nventory server, e.g. in ~/.nventory.conf:
+
+  profiles.staging.server = https://nventory-staging.example.com
+  profiles.staging.sso_server = https://sso-staging.example.com
+  profiles.staging.username = jdoe
+
+Any setting of the config file can be set for a profile, overriding the
+setting outside of profiles. The profile used is given by --profile, then
+$NV_PROFILE, then by 'profile use'. Each profile keeps its own cookies.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr, ioutil.Discard)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+			os.Exit(ExitUsage)
+		},
+	}
+
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the profiles, marking the one in use with *",
+		Run: func(cmd *cobra.Command, args []string) {
+			settings := configSettings()
+			names := nvclient.ProfileNames(settings)
+			if len(names) == 0 {
+				fmt.Println("No profiles found in the config files.")
+				return
+			}
+			for _, name := range names {
+				current := " "
+				if strings.EqualFold(name, currentProfile) {
+					current = "*"
+				}
+				fmt.Printf("%v %v\t%v\n", current, name, settings["profiles."+name+".server"])
+			}
+		},
+	})
+
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "use <profile>",
+		Short: "Use the profile by default, from ~/.nventory.conf",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				cmd.Usage()
+				os.Exit(ExitUsage)
+			}
+			if _, err := nvclient.ProfileSettings(configSettings(), args[0]); err != nil {
+				exitWithError(err)
+			}
+			filename := nvclient.ConfigFiles()[1]
+			if err := nvclient.SetConfigFileValue(filename, "profile", args[0]); err != nil {
+				exitWithError(err)
+			}
+			fmt.Printf("Using profile %v by default (%v).\n", args[0], filename)
+		},
+	})
+
+	app.AddCommand(profileCmd)
+}
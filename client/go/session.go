@@ -0,0 +1,73 @@
+// Copyright © 2016 Andrew Cheung <ac1493@yp.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/atclate/nventory/client/go/nvclient"
+	"github.com/spf13/cobra"
+)
+
+/******************************************************************************
+SetupSessionCommands:
+	Adds the login, logout and whoami commands, managing the session of
+	--username (by default the current user) kept in its cookie file.
+ *****************************************************************************/
+func SetupSessionCommands(app *cobra.Command, driver nvclient.Driver) {
+	configure := func(cmd *cobra.Command, args []string) { configureDriver(driver) }
+
+	app.AddCommand(&cobra.Command{
+		Use:    "login",
+		Short:  "Log in to the server and save the session, so later commands don't prompt for a password",
+		PreRun: configure,
+		Run: func(cmd *cobra.Command, args []string) {
+			session, err := driver.Login()
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Print(session)
+		},
+	})
+
+	app.AddCommand(&cobra.Command{
+		Use:    "logout",
+		Short:  "End the session on the SSO server and remove its cookie file",
+		PreRun: configure,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := driver.Logout(); err != nil {
+				exitWithError(err)
+			}
+			fmt.Println("Logged out.")
+		},
+	})
+
+	app.AddCommand(&cobra.Command{
+		Use:    "whoami",
+		Short:  "Show the account logged in, its server, SSO server and when the session expires",
+		PreRun: configure,
+		Run: func(cmd *cobra.Command, args []string) {
+			session, err := driver.Whoami()
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Print(session)
+			if !session.LoggedIn {
+				os.Exit(ExitAuth)
+			}
+		},
+	})
+}